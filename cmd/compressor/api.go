@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// registerAPI adds the JSON job API under /api/v1/.
+func registerAPI(mux *http.ServeMux, d *dispatcher) {
+	mux.HandleFunc("/api/v1/jobs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		listJobs(w, r)
+	})
+
+	mux.Handle("/api/v1/upload", uploadHandler(d))
+
+	mux.HandleFunc("/api/v1/queue", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, queueStatus(d))
+	})
+	mux.HandleFunc("/api/v1/queue/pause", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		d.pause()
+		writeJSON(w, http.StatusOK, queueStatus(d))
+	})
+	mux.HandleFunc("/api/v1/queue/drain", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		d.drain()
+		writeJSON(w, http.StatusOK, queueStatus(d))
+	})
+	mux.HandleFunc("/api/v1/queue/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		d.unpause()
+		writeJSON(w, http.StatusOK, queueStatus(d))
+	})
+
+	// /api/v1/jobs/{id} and /api/v1/jobs/{id}/{action}
+	mux.HandleFunc("/api/v1/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/jobs/"), "/")
+		id, action, _ := strings.Cut(rest, "/")
+		if id == "" {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+
+		switch action {
+		case "":
+			if r.Method != http.MethodGet {
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			job, ok := jobs.getByID(id)
+			if !ok {
+				writeError(w, http.StatusNotFound, errJobNotFound.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, job)
+		case "output":
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			job, ok := jobs.getByID(id)
+			if !ok {
+				writeError(w, http.StatusNotFound, errJobNotFound.Error())
+				return
+			}
+			serveOutput(w, r, job)
+		case "thumbnail":
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			job, ok := jobs.getByID(id)
+			if !ok || !job.Thumbnail {
+				writeError(w, http.StatusNotFound, "no thumbnail")
+				return
+			}
+			w.Header().Set("Cache-Control", "private, max-age=3600")
+			http.ServeFile(w, r, thumbnailPathFor(d.cfg, job.ID))
+		case "cancel", "retry", "bump":
+			if r.Method != http.MethodPost {
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			var (
+				job jobRecord
+				err error
+			)
+			switch action {
+			case "cancel":
+				job, err = d.cancel(id)
+			case "retry":
+				job, err = d.retry(id)
+			case "bump":
+				job, err = d.bump(id)
+			}
+			if err != nil {
+				writeJobError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, job)
+		default:
+			writeError(w, http.StatusNotFound, "not found")
+		}
+	})
+}
+
+// serveOutput sends the result of a finished job, with Range support.
+func serveOutput(w http.ResponseWriter, r *http.Request, job jobRecord) {
+	if (job.State != jobSucceeded && job.State != jobNotWorthIt) || job.OutputPath == "" {
+		writeError(w, http.StatusConflict, fmt.Sprintf("%v: job is %s and has no output", errJobState, job.State))
+		return
+	}
+	f, err := os.Open(job.OutputPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			writeError(w, http.StatusGone, "output no longer exists")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	name := filepath.Base(job.OutputPath)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+type queueState struct {
+	Mode          string `json:"mode"`
+	Paused        bool   `json:"paused"`
+	Queued        int    `json:"queued"`
+	Running       int    `json:"running"`
+	MaxConcurrent int    `json:"max_concurrent"`
+	Order         string `json:"order"`
+	// IDs of queued jobs in the order they will be started
+	Pending []string `json:"pending"`
+	// Only set with a SCHEDULE
+	WindowOpen *bool      `json:"window_open,omitempty"`
+	NextWindow *time.Time `json:"next_window_change,omitempty"`
+}
+
+func queueStatus(d *dispatcher) queueState {
+	queued, running := d.counts()
+	state := queueState{
+		Mode:          d.mode(),
+		Paused:        d.paused(),
+		Queued:        queued,
+		Running:       running,
+		MaxConcurrent: d.cfg.maxConcurrent,
+		Order:         d.cfg.queueOrder,
+		Pending:       []string{},
+	}
+	for _, path := range d.pending() {
+		if job, ok := jobs.get(path); ok {
+			state.Pending = append(state.Pending, job.ID)
+		}
+	}
+	if d.cfg.schedule != nil {
+		open, next := d.window()
+		state.WindowOpen = &open
+		if !next.IsZero() {
+			state.NextWindow = &next
+		}
+	}
+	return state
+}
+
+// listJobs serves the job list, optionally filtered by ?state=a,b and
+// truncated by ?limit=n.
+func listJobs(w http.ResponseWriter, r *http.Request) {
+	states := make(map[jobState]bool)
+	for _, s := range strings.Split(r.URL.Query().Get("state"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			states[jobState(s)] = true
+		}
+	}
+	limit := 0
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	list := make([]jobRecord, 0)
+	for _, job := range jobs.list() {
+		if len(states) > 0 && !states[job.State] {
+			continue
+		}
+		list = append(list, job)
+		if limit > 0 && len(list) == limit {
+			break
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"jobs": list})
+}
+
+func writeJobError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errJobNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errJobState):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("write response: %v", err)
+	}
+}
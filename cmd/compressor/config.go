@@ -4,10 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/mail"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 )
 
@@ -21,6 +25,16 @@ const (
 	defaultHTTPPort          = "8080"
 	defaultRescanInterval    = 30 * time.Second
 	defaultStabilityDuration = 3 * time.Second
+	defaultStateDirName      = ".compressor"
+	defaultStaleProcessing   = 2 * time.Minute
+	defaultLedgerTTL         = 30 * 24 * time.Hour
+	defaultMaxAttempts       = 3
+	defaultRetryBackoff      = time.Minute
+	defaultRetryBackoffMax   = time.Hour
+	defaultQuarantineDirName = ".quarantine"
+	defaultDurationTolerance = 2 * time.Second
+	defaultShutdownGrace     = 5 * time.Minute
+	defaultUploadMaxBytes    = 10 << 30
 )
 
 const defaultFFMPEGCommand = "-y -hide_banner -nostats -hwaccel cuda -hwaccel_device 0 -i {{input}} -c:v hevc_nvenc -vf format=nv12 -qp 25 -preset p6 -gpu 0 -b_qfactor 1.1 -b_ref_mode middle -bf 3 -g 250 -i_qfactor 0.75 -max_muxing_queue_size 1024 -multipass 1 -rc vbr -rc-lookahead 20 -temporal-aq 1 -tune hq -c:a aac -af volume=2.0 {{output}}"
@@ -34,16 +48,75 @@ type config struct {
 	outputDir         string
 	ffmpegBinary      string
 	ffmpegCommand     string
+	gpuAvailable      bool
+	profiles          []profile
+	defaultProfile    profile
 	deleteSource      bool
+	recursive         bool
 	processingSuffix  string
+	staleAfter        time.Duration
 	outputExtension   string
 	httpPort          string
 	discordWebhookURL string
+	notifySkipped     bool
+	slackWebhookURL   string
+	slackBotToken     string
+	slackChannel      string
+	slackAPIURL       string
+	telegramToken     string
+	telegramChatID    string
+	telegramAPIURL    string
+	gotifyURL         string
+	gotifyToken       string
+	ntfyURL           string
+	ntfyTopic         string
+	ntfyToken         string
+	webhookURL        string
+	webhookMethod     string
+	webhookHeaders    http.Header
+	webhookTemplate   *template.Template
+	webhookSecret     string
+	smtpHost          string
+	smtpPort          string
+	smtpTLS           string
+	smtpUsername      string
+	smtpPassword      string
+	smtpFrom          *mail.Address
+	smtpTo            []*mail.Address
+	smtpPerJob        bool
+	digestSchedule    *digestSchedule
 	rescanInterval    time.Duration
 	stabilityWindow   time.Duration
 	queueSize         int
+	queueOrder        string
 	maxConcurrent     int
 	extensions        map[string]struct{}
+	stateDir          string
+	ledgerPath        string
+	ledgerTTL         time.Duration
+	maxAttempts       int
+	retryBackoff      time.Duration
+	retryBackoffMax   time.Duration
+	quarantineDir     string
+	skipCodecs        map[string]struct{}
+	skipBelowKbps     int
+	skipShorterThan   time.Duration
+	minSavingsPercent float64
+	minSavingsBytes   int64
+	notWorthItAction  string
+	verifyMetric      string
+	verifyMinScore    float64
+	validateOutput    bool
+	validateDecode    bool
+	durationTolerance time.Duration
+	minVideoStreams   int
+	minAudioStreams   int
+	apiToken          string
+	uploadsEnabled    bool
+	uploadMaxBytes    int64
+	shutdownGrace     time.Duration
+	schedule          *schedule
+	schedulePolicy    string
 }
 
 func loadConfig() (config, error) {
@@ -55,11 +128,57 @@ func loadConfig() (config, error) {
 		outputExtension:   getEnv("OUTPUT_EXTENSION", defaultOutputExtension),
 		httpPort:          getEnvOrEmpty("PORT"),
 		discordWebhookURL: getEnvOrEmpty("DISCORD_WEBHOOK_URL"),
+		notifySkipped:     getEnvBool("NOTIFY_SKIPPED"),
+		slackWebhookURL:   getEnvOrEmpty("SLACK_WEBHOOK_URL"),
+		slackBotToken:     getEnvOrEmpty("SLACK_BOT_TOKEN"),
+		slackChannel:      getEnvOrEmpty("SLACK_CHANNEL"),
+		slackAPIURL:       getEnv("SLACK_API_URL", defaultSlackAPIURL),
+		telegramToken:     getEnvOrEmpty("TELEGRAM_BOT_TOKEN"),
+		telegramChatID:    getEnvOrEmpty("TELEGRAM_CHAT_ID"),
+		telegramAPIURL:    getEnv("TELEGRAM_API_URL", defaultTelegramAPIURL),
+		gotifyURL:         getEnvOrEmpty("GOTIFY_URL"),
+		gotifyToken:       getEnvOrEmpty("GOTIFY_TOKEN"),
+		ntfyURL:           getEnv("NTFY_URL", defaultNtfyURL),
+		ntfyTopic:         getEnvOrEmpty("NTFY_TOPIC"),
+		ntfyToken:         getEnvOrEmpty("NTFY_TOKEN"),
+		webhookURL:        getEnvOrEmpty("WEBHOOK_URL"),
+		webhookMethod:     strings.ToUpper(getEnv("WEBHOOK_METHOD", http.MethodPost)),
+		webhookSecret:     getEnvOrEmpty("WEBHOOK_SECRET"),
+		smtpHost:          getEnvOrEmpty("SMTP_HOST"),
+		smtpTLS:           strings.ToLower(getEnv("SMTP_TLS", smtpTLSStartTLS)),
+		smtpUsername:      getEnvOrEmpty("SMTP_USERNAME"),
+		smtpPassword:      os.Getenv("SMTP_PASSWORD"),
+		smtpPerJob:        getEnvBoolDefault("SMTP_PER_JOB", true),
 		queueSize:         getEnvInt("QUEUE_SIZE", defaultQueueSize),
+		queueOrder:        strings.ToLower(getEnv("QUEUE_ORDER", queueOrderFIFO)),
 		maxConcurrent:     getEnvInt("MAX_CONCURRENT", defaultMaxConcurrent),
 		rescanInterval:    getEnvDuration("RESCAN_INTERVAL", defaultRescanInterval),
 		stabilityWindow:   getEnvDuration("FILE_STABILITY_DURATION", defaultStabilityDuration),
+		staleAfter:        getEnvDuration("PROCESSING_STALE_AFTER", defaultStaleProcessing),
 		deleteSource:      getEnvBool("DELETE_SOURCE"),
+		recursive:         getEnvBool("RECURSIVE"),
+		ledgerTTL:         getEnvDuration("LEDGER_TTL", defaultLedgerTTL),
+		maxAttempts:       getEnvInt("MAX_ATTEMPTS", defaultMaxAttempts),
+		retryBackoff:      getEnvDuration("RETRY_BACKOFF", defaultRetryBackoff),
+		retryBackoffMax:   getEnvDuration("RETRY_BACKOFF_MAX", defaultRetryBackoffMax),
+		quarantineDir:     getEnvOrEmpty("QUARANTINE_DIR"),
+		skipBelowKbps:     getEnvInt("SKIP_BELOW_BITRATE", 0),
+		skipShorterThan:   getEnvDuration("SKIP_SHORTER_THAN", 0),
+		minSavingsPercent: getEnvFloat("MIN_SAVINGS_PERCENT", 0),
+		minSavingsBytes:   getEnvInt64("MIN_SAVINGS_BYTES", 0),
+		notWorthItAction:  strings.ToLower(getEnv("NOT_WORTH_IT_ACTION", notWorthItDiscard)),
+		verifyMetric:      strings.ToLower(getEnvOrEmpty("VERIFY_METRIC")),
+		verifyMinScore:    getEnvFloat("VERIFY_MIN_SCORE", 0),
+		validateOutput:    getEnvBoolDefault("VALIDATE_OUTPUT", true),
+		validateDecode:    getEnvBool("VALIDATE_DECODE"),
+		durationTolerance: getEnvDuration("VALIDATE_DURATION_TOLERANCE", defaultDurationTolerance),
+		minVideoStreams:   getEnvInt("VALIDATE_VIDEO_STREAMS", 1),
+		minAudioStreams:   getEnvInt("VALIDATE_AUDIO_STREAMS", 1),
+		apiToken:          getEnvOrEmpty("API_TOKEN"),
+		uploadsEnabled:    getEnvBool("UPLOADS_ENABLED"),
+		uploadMaxBytes:    getEnvInt64("UPLOAD_MAX_BYTES", defaultUploadMaxBytes),
+		shutdownGrace:     getEnvDuration("SHUTDOWN_GRACE_PERIOD", defaultShutdownGrace),
+		schedulePolicy:    strings.ToLower(getEnv("SCHEDULE_POLICY", schedulePolicyFinish)),
 	}
 
 	if cfg.maxConcurrent < 1 {
@@ -68,9 +187,66 @@ func loadConfig() (config, error) {
 	if cfg.queueSize < cfg.maxConcurrent {
 		cfg.queueSize = cfg.maxConcurrent * 2
 	}
+	if cfg.slackBotToken != "" && cfg.slackChannel == "" {
+		return cfg, errors.New("SLACK_BOT_TOKEN requires SLACK_CHANNEL")
+	}
+	if cfg.telegramToken != "" && cfg.telegramChatID == "" {
+		return cfg, errors.New("TELEGRAM_BOT_TOKEN requires TELEGRAM_CHAT_ID")
+	}
+	if cfg.gotifyURL != "" && cfg.gotifyToken == "" {
+		return cfg, errors.New("GOTIFY_URL requires GOTIFY_TOKEN")
+	}
+	if err := loadWebhookConfig(&cfg); err != nil {
+		return cfg, err
+	}
+	if err := loadSMTPConfig(&cfg); err != nil {
+		return cfg, err
+	}
+	if spec := getEnvOrEmpty("DIGEST_SCHEDULE"); spec != "" {
+		loc := time.Local
+		if tz := getEnvOrEmpty("DIGEST_TZ"); tz != "" {
+			var err error
+			if loc, err = time.LoadLocation(tz); err != nil {
+				return cfg, fmt.Errorf("invalid DIGEST_TZ: %w", err)
+			}
+		}
+		sched, err := parseDigestSchedule(spec, loc)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid DIGEST_SCHEDULE: %w", err)
+		}
+		cfg.digestSchedule = sched
+	}
+	switch cfg.queueOrder {
+	case queueOrderFIFO, queueOrderOldest, queueOrderSmallest, queueOrderSavings, queueOrderProfile:
+	default:
+		return cfg, fmt.Errorf("invalid QUEUE_ORDER %q: must be one of fifo, oldest, smallest, savings, profile", cfg.queueOrder)
+	}
 	if cfg.processingSuffix == "" {
 		cfg.processingSuffix = defaultProcessingSuffix
 	}
+	switch cfg.notWorthItAction {
+	case notWorthItDiscard, notWorthItCopy:
+	default:
+		return cfg, fmt.Errorf("invalid NOT_WORTH_IT_ACTION %q: must be %q or %q", cfg.notWorthItAction, notWorthItDiscard, notWorthItCopy)
+	}
+	switch cfg.verifyMetric {
+	case "", "off", "none":
+		cfg.verifyMetric = ""
+	case metricSSIM, metricPSNR, metricVMAF, metricAuto:
+	default:
+		return cfg, fmt.Errorf("invalid VERIFY_METRIC %q: must be one of ssim, psnr, vmaf, auto", cfg.verifyMetric)
+	}
+	if cfg.maxAttempts < 1 {
+		cfg.maxAttempts = 1
+	}
+	if cfg.quarantineDir == "" {
+		cfg.quarantineDir = filepath.Join(cfg.inputDir, defaultQuarantineDirName)
+	} else if strings.EqualFold(cfg.quarantineDir, "off") {
+		cfg.quarantineDir = ""
+	}
+	if cfg.staleAfter <= 0 {
+		cfg.staleAfter = defaultStaleProcessing
+	}
 
 	extEnv := os.Getenv("VIDEO_EXTENSIONS")
 	if strings.TrimSpace(extEnv) == "" {
@@ -91,9 +267,16 @@ func loadConfig() (config, error) {
 		return cfg, errors.New("no video extensions configured")
 	}
 
+	cfg.skipCodecs = make(map[string]struct{})
+	for _, raw := range strings.Split(os.Getenv("SKIP_CODECS"), ",") {
+		if trimmed := strings.ToLower(strings.TrimSpace(raw)); trimmed != "" {
+			cfg.skipCodecs[trimmed] = struct{}{}
+		}
+	}
+
 	// Detect GPU and set ffmpeg command
-	gpuAvailable := detectGPU()
-	if gpuAvailable {
+	cfg.gpuAvailable = detectGPU()
+	if cfg.gpuAvailable {
 		cfg.ffmpegCommand = getEnv("FFMPEG_COMMAND", defaultFFMPEGCommand)
 	} else {
 		log.Printf("GPU not detected, falling back to CPU encoding")
@@ -108,9 +291,127 @@ func loadConfig() (config, error) {
 		cfg.outputDir = filepath.Join(filepath.Dir(cfg.inputDir), "test_output")
 	}
 
+	// The environment configured command is the default profile; a profiles
+	// file adds more and may override it.
+	cfg.defaultProfile = profile{
+		Name:      defaultProfileName,
+		Command:   cfg.ffmpegCommand,
+		Extension: cfg.outputExtension,
+		OutputDir: cfg.outputDir,
+		command:   cfg.ffmpegCommand,
+	}
+	if profilesPath := getEnvOrEmpty("PROFILES_FILE"); profilesPath != "" {
+		profiles, err := loadProfiles(profilesPath, cfg.gpuAvailable)
+		if err != nil {
+			return cfg, err
+		}
+		for _, p := range profiles {
+			if p.OutputDir != "" && !filepath.IsAbs(p.OutputDir) {
+				p.OutputDir = filepath.Join(cfg.outputDir, p.OutputDir)
+			}
+			if p.Name == defaultProfileName {
+				if p.OutputDir == "" {
+					p.OutputDir = cfg.outputDir
+				}
+				cfg.defaultProfile = p
+				continue
+			}
+			cfg.profiles = append(cfg.profiles, p)
+		}
+	}
+
+	if spec := getEnvOrEmpty("SCHEDULE"); spec != "" {
+		loc := time.Local
+		if tz := getEnvOrEmpty("SCHEDULE_TZ"); tz != "" {
+			var err error
+			if loc, err = time.LoadLocation(tz); err != nil {
+				return cfg, fmt.Errorf("invalid SCHEDULE_TZ: %w", err)
+			}
+		}
+		sched, err := parseSchedule(spec, loc)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid SCHEDULE: %w", err)
+		}
+		cfg.schedule = sched
+	}
+	switch cfg.schedulePolicy {
+	case schedulePolicyFinish:
+	case schedulePolicyPause:
+		if !canSuspend {
+			return cfg, fmt.Errorf("SCHEDULE_POLICY=%s is not supported on this platform", schedulePolicyPause)
+		}
+	default:
+		return cfg, fmt.Errorf("invalid SCHEDULE_POLICY %q: must be %q or %q", cfg.schedulePolicy, schedulePolicyFinish, schedulePolicyPause)
+	}
+
+	cfg.stateDir = getEnv("STATE_DIR", filepath.Join(cfg.outputDir, defaultStateDirName))
+	cfg.ledgerPath = getEnv("LEDGER_PATH", filepath.Join(cfg.stateDir, "jobs.jsonl"))
+	if strings.EqualFold(cfg.ledgerPath, "off") {
+		cfg.ledgerPath = ""
+	}
+
 	return cfg, nil
 }
 
+func loadSMTPConfig(cfg *config) error {
+	if cfg.smtpHost == "" {
+		return nil
+	}
+	switch cfg.smtpTLS {
+	case smtpTLSStartTLS, smtpTLSNone:
+		cfg.smtpPort = getEnv("SMTP_PORT", "587")
+	case smtpTLSImplicit:
+		cfg.smtpPort = getEnv("SMTP_PORT", "465")
+	default:
+		return fmt.Errorf("invalid SMTP_TLS %q: must be %q, %q or %q", cfg.smtpTLS, smtpTLSStartTLS, smtpTLSImplicit, smtpTLSNone)
+	}
+	to := getEnvOrEmpty("SMTP_TO")
+	if to == "" {
+		return errors.New("SMTP_HOST requires SMTP_TO")
+	}
+	var err error
+	if cfg.smtpTo, err = mail.ParseAddressList(to); err != nil {
+		return fmt.Errorf("invalid SMTP_TO: %w", err)
+	}
+	from := getEnvOrEmpty("SMTP_FROM")
+	if from == "" {
+		if !strings.Contains(cfg.smtpUsername, "@") {
+			return errors.New("SMTP_HOST requires SMTP_FROM")
+		}
+		from = cfg.smtpUsername
+	}
+	if cfg.smtpFrom, err = mail.ParseAddress(from); err != nil {
+		return fmt.Errorf("invalid SMTP_FROM: %w", err)
+	}
+	return nil
+}
+
+func loadWebhookConfig(cfg *config) error {
+	var err error
+	if cfg.webhookHeaders, err = parseWebhookHeaders(os.Getenv("WEBHOOK_HEADERS")); err != nil {
+		return fmt.Errorf("invalid WEBHOOK_HEADERS: %w", err)
+	}
+
+	text := os.Getenv("WEBHOOK_TEMPLATE")
+	if path := getEnvOrEmpty("WEBHOOK_TEMPLATE_FILE"); path != "" {
+		if text != "" {
+			return errors.New("set only one of WEBHOOK_TEMPLATE and WEBHOOK_TEMPLATE_FILE")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read WEBHOOK_TEMPLATE_FILE: %w", err)
+		}
+		text = string(data)
+	}
+	if strings.TrimSpace(text) == "" {
+		text = defaultWebhookTemplate
+	}
+	if cfg.webhookTemplate, err = parseWebhookTemplate(text); err != nil {
+		return fmt.Errorf("invalid webhook template: %w", err)
+	}
+	return nil
+}
+
 func getEnv(key, fallback string) string {
 	val := strings.TrimSpace(os.Getenv(key))
 	if val == "" {
@@ -136,6 +437,14 @@ func getEnvBool(key string) bool {
 	}
 }
 
+// getEnvBoolDefault is getEnvBool for settings that are on unless disabled.
+func getEnvBoolDefault(key string, fallback bool) bool {
+	if strings.TrimSpace(os.Getenv(key)) == "" {
+		return fallback
+	}
+	return getEnvBool(key)
+}
+
 func getEnvInt(key string, fallback int) int {
 	val := strings.TrimSpace(os.Getenv(key))
 	if val == "" {
@@ -149,6 +458,32 @@ func getEnvInt(key string, fallback int) int {
 	return parsed
 }
 
+func getEnvInt64(key string, fallback int64) int64 {
+	val := strings.TrimSpace(os.Getenv(key))
+	if val == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		log.Printf("invalid int for %s: %v", key, err)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	val := strings.TrimSpace(os.Getenv(key))
+	if val == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		log.Printf("invalid float for %s: %v", key, err)
+		return fallback
+	}
+	return parsed
+}
+
 func getEnvDuration(key string, fallback time.Duration) time.Duration {
 	val := strings.TrimSpace(os.Getenv(key))
 	if val == "" {
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// digestTopSavings is the number of jobs listed under top savings.
+const digestTopSavings = 5
+
+// digestSchedule fires daily, or weekly on weekday, at minute after
+// midnight.
+type digestSchedule struct {
+	weekly  bool
+	weekday time.Weekday
+	minute  int
+	loc     *time.Location
+}
+
+// parseDigestSchedule parses "daily HH:MM" or "weekly <day> HH:MM".
+func parseDigestSchedule(spec string, loc *time.Location) (*digestSchedule, error) {
+	fields := strings.Fields(strings.ToLower(spec))
+	s := &digestSchedule{loc: loc}
+	var clock string
+	switch {
+	case len(fields) == 2 && fields[0] == "daily":
+		clock = fields[1]
+	case len(fields) == 3 && fields[0] == "weekly":
+		day, ok := weekdayNames[fields[1]]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q", fields[1])
+		}
+		s.weekly, s.weekday, clock = true, day, fields[2]
+	default:
+		return nil, fmt.Errorf("%q must be \"daily HH:MM\" or \"weekly <day> HH:MM\"", spec)
+	}
+	minute, err := parseClock(clock)
+	if err != nil {
+		return nil, err
+	}
+	if minute == minutesPerDay {
+		minute = 0
+	}
+	s.minute = minute
+	return s, nil
+}
+
+// period is the span a digest covers.
+func (s *digestSchedule) period() time.Duration {
+	if s.weekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// next returns the first time the digest is due after now.
+func (s *digestSchedule) next(now time.Time) time.Time {
+	now = now.In(s.loc)
+	t := time.Date(now.Year(), now.Month(), now.Day(), s.minute/60, s.minute%60, 0, 0, s.loc)
+	for !t.After(now) || (s.weekly && t.Weekday() != s.weekday) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, s.minute/60, s.minute%60, 0, 0, s.loc)
+	}
+	return t
+}
+
+func (s *digestSchedule) String() string {
+	clock := fmt.Sprintf("%02d:%02d", s.minute/60, s.minute%60)
+	if s.weekly {
+		return fmt.Sprintf("weekly on %s at %s (%s)", s.weekday, clock, s.loc)
+	}
+	return fmt.Sprintf("daily at %s (%s)", clock, s.loc)
+}
+
+// buildDigest summarises the records that finished between from and to.
+func buildDigest(records []jobRecord, from, to time.Time) digest {
+	d := digest{From: from, To: to}
+	for _, job := range records {
+		if job.FinishedAt == nil || job.FinishedAt.Before(from) || !job.FinishedAt.Before(to) {
+			continue
+		}
+		switch job.State {
+		case jobSucceeded:
+			d.Succeeded++
+			d.InputBytes += job.InputSize
+			d.OutputBytes += job.OutputSize
+			if job.OutputSize > 0 && job.OutputSize < job.InputSize {
+				d.TopSavings = append(d.TopSavings, job)
+			}
+		case jobNotWorthIt:
+			d.NotWorthIt++
+		case jobSkipped:
+			d.Skipped++
+		case jobFailed, jobQuarantined:
+			// Failed jobs waiting for another attempt may still succeed
+			if job.State == jobQuarantined || job.NextAttemptAt == nil {
+				d.Failures = append(d.Failures, job)
+			}
+		}
+	}
+
+	sort.Slice(d.TopSavings, func(i, j int) bool {
+		a, b := d.TopSavings[i], d.TopSavings[j]
+		return a.InputSize-a.OutputSize > b.InputSize-b.OutputSize
+	})
+	if len(d.TopSavings) > digestTopSavings {
+		d.TopSavings = d.TopSavings[:digestTopSavings]
+	}
+	sort.Slice(d.Failures, func(i, j int) bool {
+		return d.Failures[i].FinishedAt.Before(*d.Failures[j].FinishedAt)
+	})
+	return d
+}
+
+// empty reports whether no job finished in the period.
+func (d digest) empty() bool {
+	return d.Succeeded == 0 && d.NotWorthIt == 0 && d.Skipped == 0 && len(d.Failures) == 0
+}
+
+// runDigests builds a digest from the job ledger whenever DIGEST_SCHEDULE is
+// due and sends it to out until ctx is done. Periods in which no job
+// finished are not reported.
+func runDigests(ctx context.Context, cfg config, out chan<- digest) {
+	if cfg.digestSchedule == nil {
+		return
+	}
+	for {
+		next := cfg.digestSchedule.next(time.Now())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		d := buildDigest(jobs.list(), next.Add(-cfg.digestSchedule.period()), next)
+		if d.empty() {
+			log.Printf("digest: no jobs finished since %s, nothing to send", d.From.Format(time.RFC3339))
+			continue
+		}
+		select {
+		case out <- d:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
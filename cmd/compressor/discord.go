@@ -2,14 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -40,161 +41,235 @@ type DiscordMessage struct {
 	Embeds []DiscordEmbed `json:"embeds"`
 }
 
-func sendDiscordSuccess(webhookURL, fileName string, originalSize, compressedSize int64) {
-	sendDiscordSuccessWithThumbnail(webhookURL, fileName, originalSize, compressedSize, "")
+// discordNotifier posts embeds to a Discord webhook.
+type discordNotifier struct {
+	webhookURL string
 }
 
-func sendDiscordSuccessWithThumbnail(webhookURL, fileName string, originalSize, compressedSize int64, thumbnailPath string) {
-	if webhookURL == "" {
-		return
-	}
+func (d *discordNotifier) Name() string { return "discord" }
 
+func (d *discordNotifier) Success(ctx context.Context, n notification) error {
+	job := n.Job
 	embed := DiscordEmbed{
 		Title:       "✅ Compression Successful",
-		Description: fmt.Sprintf("compressed: **%s**", filepath.Base(fileName)),
+		Description: fmt.Sprintf("compressed: **%s**", n.FileName()),
 		Color:       0x00ff00, // Green
 		Fields: []DiscordEmbedField{
 			{
 				Name:   "Original Size",
-				Value:  formatFileSize(originalSize),
+				Value:  formatFileSize(job.InputSize),
 				Inline: true,
 			},
 			{
 				Name:   "Compressed Size",
-				Value:  formatFileSize(compressedSize),
+				Value:  formatFileSize(job.OutputSize),
 				Inline: true,
 			},
 			{
 				Name:   "Space Saved",
-				Value:  formatFileSize(originalSize - compressedSize),
+				Value:  formatFileSize(n.Saved()),
 				Inline: true,
 			},
 			{
 				Name:   "Compression Ratio",
-				Value:  fmt.Sprintf("%.1f%%", float64(compressedSize)/float64(originalSize)*100),
+				Value:  fmt.Sprintf("%.1f%%", n.Ratio()),
 				Inline: true,
 			},
 		},
 		Timestamp: time.Now().Format(time.RFC3339),
 	}
+	if job.Quality != nil {
+		embed.Fields = append(embed.Fields, DiscordEmbedField{Name: "Quality", Value: job.Quality.String(), Inline: true})
+	}
+	if job.Profile != "" && job.Profile != defaultProfileName {
+		embed.Fields = append(embed.Fields, DiscordEmbedField{Name: "Profile", Value: job.Profile, Inline: true})
+	}
 
 	// Add thumbnail image to embed if available
-	if thumbnailPath != "" {
+	if n.Thumbnail != "" {
 		embed.Image = &DiscordEmbedImage{
 			URL: "attachment://thumbnail.jpg",
 		}
 	}
 
-	sendDiscordMessageWithAttachment(webhookURL, embed, thumbnailPath, "thumbnail.jpg")
+	return d.send(ctx, embed, n.Thumbnail, "thumbnail.jpg")
 }
 
-func sendDiscordFailure(webhookURL, fileName, errorMsg string) {
-	if webhookURL == "" {
-		return
+func (d *discordNotifier) Skip(ctx context.Context, n notification) error {
+	job := n.Job
+	if job.State != jobNotWorthIt {
+		embed := DiscordEmbed{
+			Title:       "⏭️ Compression Skipped",
+			Description: fmt.Sprintf("left as is: **%s**", n.FileName()),
+			Color:       0x808080, // Grey
+			Fields: []DiscordEmbedField{
+				{
+					Name:   "Reason",
+					Value:  n.Message,
+					Inline: false,
+				},
+			},
+			Timestamp: time.Now().Format(time.RFC3339),
+		}
+		return d.send(ctx, embed, "", "")
+	}
+
+	outcome := "Compressed output discarded, original kept"
+	if job.OutputPath != "" {
+		outcome = "Compressed output discarded, original copied to output"
 	}
 
+	embed := DiscordEmbed{
+		Title:       "⚠️ Compression Not Worth It",
+		Description: fmt.Sprintf("kept original: **%s**", n.FileName()),
+		Color:       0xffcc00, // Yellow
+		Fields: []DiscordEmbedField{
+			{
+				Name:   "Original Size",
+				Value:  formatFileSize(job.InputSize),
+				Inline: true,
+			},
+			{
+				Name:   "Compressed Size",
+				Value:  formatFileSize(job.OutputSize),
+				Inline: true,
+			},
+			{
+				Name:   "Reason",
+				Value:  n.Message,
+				Inline: false,
+			},
+			{
+				Name:   "Outcome",
+				Value:  outcome,
+				Inline: false,
+			},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+
+	return d.send(ctx, embed, "", "")
+}
+
+func (d *discordNotifier) Failure(ctx context.Context, n notification) error {
 	embed := DiscordEmbed{
 		Title:       "❌ Compression Failed",
-		Description: fmt.Sprintf("Failed to compress **%s**", filepath.Base(fileName)),
+		Description: fmt.Sprintf("Failed to compress **%s**", n.FileName()),
 		Color:       0xff0000, // Red
 		Fields: []DiscordEmbedField{
 			{
 				Name:   "Error",
-				Value:  errorMsg,
+				Value:  discordFieldValue(n.Message),
 				Inline: false,
 			},
 		},
 		Timestamp: time.Now().Format(time.RFC3339),
 	}
 
-	sendDiscordMessage(webhookURL, embed)
-}
-
-func sendDiscordMessage(webhookURL string, embed DiscordEmbed) {
-	sendDiscordMessageWithAttachment(webhookURL, embed, "", "")
+	return d.send(ctx, embed, "", "")
 }
 
-func sendDiscordMessageWithAttachment(webhookURL string, embed DiscordEmbed, attachmentPath, attachmentName string) {
-	message := DiscordMessage{
-		Embeds: []DiscordEmbed{embed},
+func (d *discordNotifier) Digest(ctx context.Context, dg digest) error {
+	embed := DiscordEmbed{
+		Title:       "📊 Compression Digest",
+		Description: fmt.Sprintf("%s – %s", dg.From.Format("Jan 2 15:04"), dg.To.Format("Jan 2 15:04")),
+		Color:       0x2563eb, // Blue
+		Fields: []DiscordEmbedField{
+			{
+				Name:   "Compressed",
+				Value:  fmt.Sprintf("%d", dg.Succeeded),
+				Inline: true,
+			},
+			{
+				Name:   "Space Saved",
+				Value:  formatFileSize(dg.Saved()),
+				Inline: true,
+			},
+			{
+				Name:   "Not Worth It / Skipped",
+				Value:  fmt.Sprintf("%d / %d", dg.NotWorthIt, dg.Skipped),
+				Inline: true,
+			},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
 	}
-
-	if attachmentPath == "" {
-		// Send JSON-only message
-		jsonData, err := json.Marshal(message)
-		if err != nil {
-			log.Printf("Failed to marshal Discord message: %v", err)
-			return
-		}
-
-		resp, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			log.Printf("Failed to send Discord webhook: %v", err)
-			return
+	if len(dg.TopSavings) > 0 {
+		var lines []string
+		for _, job := range dg.TopSavings {
+			lines = append(lines, fmt.Sprintf("%s: %s saved", filepath.Base(job.Path), formatFileSize(job.InputSize-job.OutputSize)))
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-			log.Printf("Discord webhook returned status %d", resp.StatusCode)
+		embed.Fields = append(embed.Fields, DiscordEmbedField{Name: "Top Savings", Value: discordFieldValue(strings.Join(lines, "\n"))})
+	}
+	if len(dg.Failures) > 0 {
+		var lines []string
+		for _, job := range dg.Failures {
+			lines = append(lines, fmt.Sprintf("%s: %s", filepath.Base(job.Path), job.Error))
 		}
-		return
+		embed.Fields = append(embed.Fields, DiscordEmbedField{Name: fmt.Sprintf("Failures (%d)", len(dg.Failures)), Value: discordFieldValue(strings.Join(lines, "\n"))})
 	}
 
-	// Send message with file attachment using multipart/form-data
-	var b bytes.Buffer
-	w := multipart.NewWriter(&b)
-
-	// Add the JSON payload
-	jsonData, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Failed to marshal Discord message: %v", err)
-		return
-	}
+	return d.send(ctx, embed, "", "")
+}
 
-	if err := w.WriteField("payload_json", string(jsonData)); err != nil {
-		log.Printf("Failed to write payload_json field: %v", err)
-		return
-	}
+// discordFieldValue shortens s to the 1024 characters Discord allows in a
+// field.
+func discordFieldValue(s string) string {
+	return truncateRunes(s, 1024)
+}
 
-	// Add the file attachment
-	file, err := os.Open(attachmentPath)
-	if err != nil {
-		log.Printf("Failed to open attachment file: %v", err)
-		return
+// send posts embed to the webhook, with the file at attachmentPath attached
+// under attachmentName if it is set.
+func (d *discordNotifier) send(ctx context.Context, embed DiscordEmbed, attachmentPath, attachmentName string) error {
+	message := DiscordMessage{
+		Embeds: []DiscordEmbed{embed},
 	}
-	defer file.Close()
-
-	fw, err := w.CreateFormFile("file", attachmentName)
+	jsonData, err := json.Marshal(message)
 	if err != nil {
-		log.Printf("Failed to create form file: %v", err)
-		return
+		return fmt.Errorf("marshal Discord message: %w", err)
 	}
 
-	if _, err := io.Copy(fw, file); err != nil {
-		log.Printf("Failed to copy file data: %v", err)
-		return
+	body, contentType := io.Reader(bytes.NewReader(jsonData)), "application/json"
+	if attachmentPath != "" {
+		// Send message with file attachment using multipart/form-data
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		if err := w.WriteField("payload_json", string(jsonData)); err != nil {
+			return fmt.Errorf("write payload_json field: %w", err)
+		}
+		file, err := os.Open(attachmentPath)
+		if err != nil {
+			return fmt.Errorf("open attachment: %w", err)
+		}
+		defer file.Close()
+		fw, err := w.CreateFormFile("file", attachmentName)
+		if err != nil {
+			return fmt.Errorf("create form file: %w", err)
+		}
+		if _, err := io.Copy(fw, file); err != nil {
+			return fmt.Errorf("copy attachment: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("close multipart body: %w", err)
+		}
+		body, contentType = &b, w.FormDataContentType()
 	}
 
-	w.Close()
-
-	req, err := http.NewRequest("POST", webhookURL, &b)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.webhookURL, body)
 	if err != nil {
-		log.Printf("Failed to create HTTP request: %v", err)
-		return
+		return fmt.Errorf("create Discord request: %w", err)
 	}
-	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("Content-Type", contentType)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := notifyClient.Do(req)
 	if err != nil {
-		log.Printf("Failed to send Discord webhook with attachment: %v", err)
-		return
+		return fmt.Errorf("send Discord webhook: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		log.Printf("Discord webhook with attachment returned status %d", resp.StatusCode)
+		return fmt.Errorf("Discord webhook returned status %d", resp.StatusCode)
 	}
+	return nil
 }
 
 func formatFileSize(bytes int64) string {
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	errJobCancelled = errors.New("cancelled")
+	errJobNotFound  = errors.New("job not found")
+	errJobState     = errors.New("job is not in a state that allows this")
+	errShutdown     = errors.New("interrupted by shutdown")
+)
+
+// dispatcher feeds queued files to processFile in QUEUE_ORDER, keeping at
+// most maxConcurrent encodes in flight, and lets individual jobs be cancelled
+// or moved to the front.
+type dispatcher struct {
+	cfg        config
+	sem        chan struct{}
+	wg         sync.WaitGroup
+	inProgress sync.Map // paths that are queued or running
+
+	mu      sync.Mutex
+	queue   *jobQueue
+	running map[string]context.CancelCauseFunc
+
+	// No jobs are started while any of these hold
+	held       bool          // paused by the API or a signal
+	draining   bool          // paused by drain, reports not ready once idle
+	offWindow  bool          // outside the SCHEDULE
+	nextWindow time.Time     // when offWindow next changes
+	stopping   bool          // shutting down
+	wake       chan struct{} // closed and replaced when any of the above or the queue change
+}
+
+func newDispatcher(cfg config) *dispatcher {
+	return &dispatcher{
+		cfg:     cfg,
+		sem:     make(chan struct{}, cfg.maxConcurrent),
+		queue:   newJobQueue(cfg.queueOrder),
+		running: make(map[string]context.CancelCauseFunc),
+		wake:    make(chan struct{}),
+	}
+}
+
+// run starts jobs as they come up in the queue until ctx is done. Jobs do not
+// inherit ctx; they are only stopped through cancel and shutdown.
+func (d *dispatcher) run(ctx context.Context) {
+	for {
+		jobCtx, cancel := context.WithCancelCause(context.Background())
+		path, ok := d.next(ctx, cancel)
+		if !ok {
+			cancel(nil)
+			return
+		}
+
+		go func() {
+			defer func() {
+				d.mu.Lock()
+				delete(d.running, path)
+				d.mu.Unlock()
+				cancel(nil)
+				<-d.sem
+				d.inProgress.Delete(path)
+				d.wg.Done()
+			}()
+
+			if err := processFile(jobCtx, d.cfg, path); err != nil {
+				log.Printf("process failed for %s: %v", path, err)
+			}
+		}()
+	}
+}
+
+// shutdown stops starting jobs and waits for running ones. Jobs that have
+// not started encoding yet are stopped right away, encodes are given until
+// grace is done and then killed. Interrupted jobs have their source restored
+// and are picked up again on the next start.
+func (d *dispatcher) shutdown(grace context.Context) {
+	d.mu.Lock()
+	d.stopping = true
+	d.changedLocked()
+	for path, cancel := range d.running {
+		if job, ok := jobs.get(path); !ok || job.State != jobRunning {
+			cancel(errShutdown)
+		}
+	}
+	running := len(d.running)
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	if running > 0 {
+		if deadline, ok := grace.Deadline(); ok {
+			log.Printf("shutdown: waiting up to %v for %d running job(s)", time.Until(deadline).Round(time.Second), running)
+		}
+	}
+	select {
+	case <-done:
+		return
+	case <-grace.Done():
+	}
+
+	d.mu.Lock()
+	for path, cancel := range d.running {
+		log.Printf("shutdown: stopping %s", path)
+		cancel(errShutdown)
+	}
+	d.mu.Unlock()
+	<-done
+}
+
+// next waits until the dispatcher may start a job, a slot is free and the
+// queue is not empty. It takes the slot, moves the front of the queue to
+// running under cancel and returns its path. It returns false if ctx is done
+// or the dispatcher is stopping.
+func (d *dispatcher) next(ctx context.Context, cancel context.CancelCauseFunc) (string, bool) {
+	for {
+		d.mu.Lock()
+		ready, wake := d.readyLocked(), d.wake
+		d.mu.Unlock()
+		if !ready {
+			select {
+			case <-ctx.Done():
+				return "", false
+			case <-wake:
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return "", false
+		case d.sem <- struct{}{}:
+		}
+
+		d.mu.Lock()
+		if d.stopping {
+			d.mu.Unlock()
+			<-d.sem
+			return "", false
+		}
+		// Paused or emptied while waiting for the slot
+		if !d.readyLocked() {
+			d.mu.Unlock()
+			<-d.sem
+			continue
+		}
+		path := d.queue.pop()
+		d.running[path] = cancel
+		// Added under mu so shutdown cannot start waiting in between
+		d.wg.Add(1)
+		d.mu.Unlock()
+		return path, true
+	}
+}
+
+func (d *dispatcher) readyLocked() bool {
+	return !d.blockedLocked() && !d.stopping && d.queue.Len() > 0
+}
+
+func (d *dispatcher) blockedLocked() bool {
+	return d.held || d.draining || d.offWindow
+}
+
+// changedLocked wakes up next after a change to the pause state or the queue.
+func (d *dispatcher) changedLocked() {
+	close(d.wake)
+	d.wake = make(chan struct{})
+}
+
+// pause stops new jobs from starting. Running jobs are left to finish.
+func (d *dispatcher) pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.held {
+		d.held = true
+		d.changedLocked()
+		log.Printf("paused: no new jobs will be started")
+	}
+}
+
+// drain pauses the dispatcher and lets running jobs finish, after which the
+// instance reports not ready. unpause ends draining.
+func (d *dispatcher) drain() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.draining {
+		d.draining = true
+		d.changedLocked()
+		log.Printf("draining: waiting for %d running job(s) to finish", len(d.running))
+	}
+}
+
+func (d *dispatcher) unpause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.held || d.draining {
+		d.held, d.draining = false, false
+		d.changedLocked()
+		log.Printf("resumed")
+	}
+}
+
+// togglePause pauses a running dispatcher and resumes a paused or draining
+// one.
+func (d *dispatcher) togglePause() {
+	if d.paused() {
+		d.unpause()
+	} else {
+		d.pause()
+	}
+}
+
+// paused reports whether the dispatcher was paused or drained on request.
+// Being outside the schedule does not count.
+func (d *dispatcher) paused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.held || d.draining
+}
+
+// setWindow records whether the schedule currently allows new jobs and when
+// that changes next. It reports whether open differs from before.
+func (d *dispatcher) setWindow(open bool, next time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextWindow = next
+	if d.offWindow == !open {
+		return false
+	}
+	d.offWindow = !open
+	d.changedLocked()
+	if open {
+		log.Printf("schedule: window open, starting jobs")
+	} else {
+		log.Printf("schedule: window closed until %s", next.Format("Mon 15:04"))
+	}
+	return true
+}
+
+// window returns whether the dispatcher is inside the schedule and when that
+// changes next. next is zero without a schedule.
+func (d *dispatcher) window() (open bool, next time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return !d.offWindow, d.nextWindow
+}
+
+// mode describes the dispatcher as "running", "paused", "draining",
+// "drained" (draining with no jobs left running), "outside_window" or
+// "stopping".
+func (d *dispatcher) mode() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case d.stopping:
+		return "stopping"
+	case d.draining && len(d.running) == 0:
+		return "drained"
+	case d.draining:
+		return "draining"
+	case d.held:
+		return "paused"
+	case d.offWindow:
+		return "outside_window"
+	default:
+		return "running"
+	}
+}
+
+// counts returns the number of queued and running jobs.
+func (d *dispatcher) counts() (queued, running int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.queue.Len(), len(d.running)
+}
+
+// pending returns the queued paths in the order they will be started.
+func (d *dispatcher) pending() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.queue.sorted()
+}
+
+// enqueue queues path unless it is already queued or running, or the ledger
+// says it should be held back. QUEUE_SIZE is a soft limit: once the queue is
+// full further files are left for a later rescan.
+func (d *dispatcher) enqueue(path string) {
+	d.add(path, false)
+}
+
+// submit queues path like enqueue, even past QUEUE_SIZE, and reports whether
+// it was queued. It is used for files handed over through the API.
+func (d *dispatcher) submit(path string) bool {
+	return d.add(path, true)
+}
+
+func (d *dispatcher) add(path string, force bool) bool {
+	if !shouldProcess(d.cfg, path) {
+		return false
+	}
+	if _, loaded := d.inProgress.LoadOrStore(path, struct{}{}); loaded {
+		return false
+	}
+	// Skip files the ledger already has a final outcome for to prevent loops
+	info, err := os.Stat(path)
+	if err != nil || jobs.shouldHold(path, info) {
+		d.inProgress.Delete(path)
+		return false
+	}
+	if !force {
+		if queued, _ := d.counts(); queued >= d.cfg.queueSize {
+			d.inProgress.Delete(path)
+			return false
+		}
+	}
+	job := jobs.update(path, func(j *jobRecord) {
+		j.State = jobQueued
+		j.Error = ""
+	})
+	item := newQueueItem(d.cfg, path, info)
+	events.publish(event{Type: eventDiscovered, Job: job})
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queue.push(item)
+	d.changedLocked()
+	return true
+}
+
+// cancel stops a queued or running job. A running job has its ffmpeg process
+// killed; its source is restored as after any other interrupted encode.
+func (d *dispatcher) cancel(id string) (jobRecord, error) {
+	job, ok := jobs.getByID(id)
+	if !ok {
+		return job, errJobNotFound
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cancel, ok := d.running[job.Path]; ok {
+		log.Printf("cancelling %s", job.Path)
+		cancel(errJobCancelled)
+		return job, nil
+	}
+	if d.queue.remove(job.Path) {
+		d.inProgress.Delete(job.Path)
+		d.changedLocked()
+		job = jobs.update(job.Path, func(j *jobRecord) {
+			now := time.Now()
+			j.State = jobCancelled
+			j.FinishedAt = &now
+		})
+		events.publish(event{Type: eventCancelled, Job: job, Message: errJobCancelled.Error()})
+		return job, nil
+	}
+	return job, fmt.Errorf("%w: job is %s", errJobState, job.State)
+}
+
+// retry re-queues a job that failed, was quarantined or was cancelled, with a
+// fresh set of attempts.
+func (d *dispatcher) retry(id string) (jobRecord, error) {
+	job, ok := jobs.getByID(id)
+	if !ok {
+		return job, errJobNotFound
+	}
+	switch job.State {
+	case jobFailed, jobQuarantined, jobCancelled:
+	default:
+		return job, fmt.Errorf("%w: job is %s", errJobState, job.State)
+	}
+
+	if job.QuarantinePath != "" {
+		if _, err := os.Stat(job.Path); err == nil {
+			return job, fmt.Errorf("%w: %s exists again", errJobState, job.Path)
+		}
+		if err := moveFile(job.QuarantinePath, job.Path); err != nil {
+			return job, fmt.Errorf("restore from quarantine: %w", err)
+		}
+		if err := os.Remove(job.QuarantinePath + ".error.json"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("remove error report for %s failed: %v", job.QuarantinePath, err)
+		}
+	}
+
+	jobs.update(job.Path, func(j *jobRecord) {
+		j.State = jobQueued
+		j.Attempts = 0
+		j.NextAttemptAt = nil
+		j.QuarantinePath = ""
+	})
+	if !d.submit(job.Path) {
+		if _, queued := d.inProgress.Load(job.Path); !queued {
+			job = jobs.update(job.Path, func(j *jobRecord) {
+				j.State = jobFailed
+				j.Error = "retry: source file is no longer available"
+			})
+			return job, fmt.Errorf("%w: source file is no longer available", errJobState)
+		}
+	}
+	log.Printf("retrying %s", job.Path)
+	job, _ = jobs.getByID(id)
+	return job, nil
+}
+
+// bump moves a queued job to the front of the queue, ahead of jobs bumped
+// earlier.
+func (d *dispatcher) bump(id string) (jobRecord, error) {
+	job, ok := jobs.getByID(id)
+	if !ok {
+		return job, errJobNotFound
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.queue.bump(job.Path) {
+		return job, fmt.Errorf("%w: job is %s", errJobState, job.State)
+	}
+	d.changedLocked()
+	log.Printf("moved %s to the front of the queue", job.Path)
+	return job, nil
+}
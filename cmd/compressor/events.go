@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type eventType string
+
+const (
+	eventDiscovered  eventType = "discovered"
+	eventStable      eventType = "stable"
+	eventStarted     eventType = "started"
+	eventProgress    eventType = "progress"
+	eventSucceeded   eventType = "succeeded"
+	eventNotWorthIt  eventType = "not_worth_it"
+	eventFailed      eventType = "failed"
+	eventSkipped     eventType = "skipped"
+	eventCancelled   eventType = "cancelled"
+	eventQuarantined eventType = "quarantined"
+)
+
+// finished reports whether the event ends a processFile run.
+func (t eventType) finished() bool {
+	switch t {
+	case eventSucceeded, eventNotWorthIt, eventFailed, eventSkipped, eventCancelled, eventQuarantined:
+		return true
+	default:
+		return false
+	}
+}
+
+// event is a step in a job's lifecycle. Job is a snapshot of the ledger
+// record at the time of the event.
+type event struct {
+	Seq     uint64    `json:"seq"`
+	Type    eventType `json:"type"`
+	Time    time.Time `json:"time"`
+	Job     jobRecord `json:"job"`
+	Message string    `json:"message,omitempty"`
+	// Retrying marks a failed encode attempt that will be tried again.
+	// Notifications are not sent for those.
+	Retrying bool `json:"retrying,omitempty"`
+}
+
+// eventBus fans events out to subscribers. Publishing never blocks: a
+// regular subscriber that falls too far behind misses events, while reliable
+// subscribers queue them without limit.
+type eventBus struct {
+	mu   sync.Mutex
+	seq  uint64
+	subs map[chan event]*subscriber
+}
+
+type subscriber struct {
+	name string
+	ch   chan event
+	// reliable subscribers receive events through queue.
+	queue *eventQueue
+}
+
+var events = &eventBus{subs: make(map[chan event]*subscriber)}
+
+// subscribe returns a channel receiving all events published from now on
+// and a function that ends the subscription. name is used in log messages.
+func (b *eventBus) subscribe(name string, buffer int) (<-chan event, func()) {
+	return b.add(&subscriber{name: name}, buffer)
+}
+
+// subscribeReliable is like subscribe, but skips progress events and never
+// drops any other. It is meant for consumers that act on job outcomes, such
+// as notifications and metrics. Events queue up while the consumer is busy,
+// and ending the subscription closes the channel once they are delivered.
+func (b *eventBus) subscribeReliable(name string, buffer int) (<-chan event, func()) {
+	return b.add(&subscriber{name: name, queue: newEventQueue()}, buffer)
+}
+
+func (b *eventBus) add(sub *subscriber, buffer int) (<-chan event, func()) {
+	sub.ch = make(chan event, buffer)
+	b.mu.Lock()
+	b.subs[sub.ch] = sub
+	b.mu.Unlock()
+	if sub.queue != nil {
+		go sub.queue.forward(sub.ch)
+	}
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, sub.ch)
+			b.mu.Unlock()
+			if sub.queue != nil {
+				sub.queue.stop()
+			} else {
+				close(sub.ch)
+			}
+		})
+	}
+}
+
+func (b *eventBus) publish(e event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	e.Seq = b.seq
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, sub := range b.subs {
+		if sub.queue != nil {
+			if e.Type != eventProgress {
+				sub.queue.push(e)
+			}
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			if e.Type != eventProgress {
+				log.Printf("events: %s is falling behind, dropped %s event for %s", sub.name, e.Type, e.Job.Path)
+			}
+		}
+	}
+}
+
+// eventQueue is an unbounded FIFO between the bus and a reliable subscriber,
+// so a slow consumer holds up neither publishers nor other subscribers.
+type eventQueue struct {
+	mu      sync.Mutex
+	pending []event
+	stopped bool
+	wake    chan struct{}
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{wake: make(chan struct{}, 1)}
+}
+
+func (q *eventQueue) push(e event) {
+	q.mu.Lock()
+	q.pending = append(q.pending, e)
+	q.mu.Unlock()
+	q.signal()
+}
+
+// stop lets forward deliver what is queued and then close its channel.
+func (q *eventQueue) stop() {
+	q.mu.Lock()
+	q.stopped = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *eventQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// forward delivers queued events to ch in order until the queue is stopped
+// and drained.
+func (q *eventQueue) forward(ch chan<- event) {
+	defer close(ch)
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 && !q.stopped {
+			q.mu.Unlock()
+			<-q.wake
+			q.mu.Lock()
+		}
+		if len(q.pending) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		e := q.pending[0]
+		q.pending[0] = event{}
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+		ch <- e
+	}
+}
+
+// publishJob publishes an event for the current state of the job at path.
+func publishJob(t eventType, path, message string) {
+	job, ok := jobs.get(path)
+	if !ok {
+		return
+	}
+	events.publish(event{Type: t, Job: job, Message: message})
+}
+
+// eventsHandler streams events as Server-Sent Events. ?types=a,b limits the
+// stream to the given event types. Streams end when done is cancelled.
+func eventsHandler(done context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, "streaming not supported")
+			return
+		}
+		types := make(map[eventType]bool)
+		for _, t := range strings.Split(r.URL.Query().Get("types"), ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types[eventType(t)] = true
+			}
+		}
+
+		ch, unsubscribe := events.subscribe("sse "+r.RemoteAddr, 256)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, ": connected\n\n")
+		flusher.Flush()
+
+		keepalive := time.NewTicker(15 * time.Second)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-done.Done():
+				return
+			case <-keepalive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			case e := <-ch:
+				if len(types) > 0 && !types[e.Type] {
+					continue
+				}
+				data, err := json.Marshal(e)
+				if err != nil {
+					log.Printf("events: marshal %s: %v", e.Type, err)
+					continue
+				}
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data)
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Gotify priorities for regular notifications and failures.
+const (
+	gotifyPriority       = 5
+	gotifyUrgentPriority = 8
+)
+
+// gotifyNotifier pushes messages to a Gotify server. Gotify has no
+// attachments, so thumbnails are not sent.
+type gotifyNotifier struct {
+	url   string
+	token string
+}
+
+func (g *gotifyNotifier) Name() string { return "gotify" }
+
+func (g *gotifyNotifier) Success(ctx context.Context, n notification) error {
+	return g.send(ctx, pushSuccess(n))
+}
+
+func (g *gotifyNotifier) Skip(ctx context.Context, n notification) error {
+	return g.send(ctx, pushSkip(n))
+}
+
+func (g *gotifyNotifier) Failure(ctx context.Context, n notification) error {
+	return g.send(ctx, pushFailure(n))
+}
+
+func (g *gotifyNotifier) Digest(ctx context.Context, d digest) error {
+	return g.send(ctx, pushDigest(d))
+}
+
+func (g *gotifyNotifier) send(ctx context.Context, msg pushMessage) error {
+	priority := gotifyPriority
+	if msg.Urgent {
+		priority = gotifyUrgentPriority
+	}
+	body, err := json.Marshal(map[string]any{
+		"title":    msg.Title,
+		"message":  msg.Body,
+		"priority": priority,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal Gotify message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(g.url, "/")+"/message", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create Gotify request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.token)
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("send Gotify message: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Gotify returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
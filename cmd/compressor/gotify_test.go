@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGotifySend(t *testing.T) {
+	type message struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+	}
+	var (
+		got  []message
+		keys []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/message" {
+			http.NotFound(w, r)
+			return
+		}
+		var msg message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got = append(got, msg)
+		keys = append(keys, r.Header.Get("X-Gotify-Key"))
+		w.Write([]byte(`{"id": 1}`))
+	}))
+	defer srv.Close()
+
+	g := &gotifyNotifier{url: srv.URL + "/", token: "app-token"}
+	ctx := context.Background()
+	if err := g.Skip(ctx, notification{Job: jobRecord{Path: "/in/a.mkv", State: jobSkipped}, Message: "already HEVC"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Failure(ctx, notification{Job: jobRecord{Path: "/in/b.mkv", State: jobFailed}, Message: "exit 1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []message{
+		{Title: "⏭️ Compression Skipped", Message: "a.mkv\nalready HEVC", Priority: gotifyPriority},
+		{Title: "❌ Compression Failed", Message: "b.mkv\nexit 1", Priority: gotifyUrgentPriority},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("message %d = %+v, want %+v", i, got[i], want[i])
+		}
+		if keys[i] != "app-token" {
+			t.Errorf("message %d X-Gotify-Key = %q", i, keys[i])
+		}
+	}
+}
+
+func TestGotifyStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	g := &gotifyNotifier{url: srv.URL, token: "wrong"}
+	err := g.Digest(context.Background(), digest{})
+	if err == nil || err.Error() != `Gotify returned status 401: {"error":"Unauthorized"}` {
+		t.Errorf("Digest() error = %v, want status 401", err)
+	}
+}
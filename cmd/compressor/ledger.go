@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type jobState string
+
+const (
+	jobQueued    jobState = "queued"
+	jobRunning   jobState = "running"
+	jobSucceeded jobState = "succeeded"
+	// jobFailed is a failed attempt, retried at NextAttemptAt. Without a
+	// next attempt the failure is final.
+	jobFailed  jobState = "failed"
+	jobSkipped jobState = "skipped"
+	// jobNotWorthIt is an encode that finished but did not save enough space.
+	jobNotWorthIt jobState = "not_worth_it"
+	jobCancelled  jobState = "cancelled"
+	// jobQuarantined is a failure that will not be retried automatically.
+	jobQuarantined jobState = "quarantined"
+)
+
+// terminal reports whether the job has reached a final state.
+func (s jobState) terminal() bool {
+	switch s {
+	case jobSucceeded, jobFailed, jobSkipped, jobNotWorthIt, jobCancelled, jobQuarantined:
+		return true
+	default:
+		return false
+	}
+}
+
+type jobRecord struct {
+	ID             string         `json:"id"`
+	Path           string         `json:"path"`
+	State          jobState       `json:"state"`
+	OutputPath     string         `json:"output_path,omitempty"`
+	Profile        string         `json:"profile,omitempty"`
+	PinnedProfile  string         `json:"pinned_profile,omitempty"`
+	InputSize      int64          `json:"input_size,omitempty"`
+	OutputSize     int64          `json:"output_size,omitempty"`
+	Probe          *probeResult   `json:"probe,omitempty"`
+	Quality        *qualityResult `json:"quality,omitempty"`
+	Thumbnail      bool           `json:"thumbnail,omitempty"`
+	SourceModTime  time.Time      `json:"source_mod_time"`
+	Error          string         `json:"error,omitempty"`
+	Reason         string         `json:"reason,omitempty"`
+	Attempts       int            `json:"attempts,omitempty"`
+	NextAttemptAt  *time.Time     `json:"next_attempt_at,omitempty"`
+	QuarantinePath string         `json:"quarantine_path,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	StartedAt      *time.Time     `json:"started_at,omitempty"`
+	FinishedAt     *time.Time     `json:"finished_at,omitempty"`
+	Progress       *jobProgress   `json:"progress,omitempty"`
+}
+
+// jobLedger keeps one record per source path and persists every change to an
+// append-only JSON lines journal. The journal is replayed on startup and
+// rewritten whenever it grows well past the number of live records.
+type jobLedger struct {
+	mu      sync.Mutex
+	path    string
+	ttl     time.Duration
+	file    *os.File
+	lines   int
+	records map[string]*jobRecord // keyed by source path
+	byID    map[string]string
+}
+
+// jobs is the process wide ledger, opened in main.
+var jobs *jobLedger
+
+// openLedger loads the journal at path. An empty path keeps the ledger in
+// memory only.
+func openLedger(path string, ttl time.Duration) (*jobLedger, error) {
+	l := &jobLedger{
+		path:    path,
+		ttl:     ttl,
+		records: make(map[string]*jobRecord),
+		byID:    make(map[string]string),
+	}
+	if path == "" {
+		return l, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("ensure ledger dir: %w", err)
+	}
+
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	l.expireLocked(time.Now())
+	if err := l.rewriteLocked(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *jobLedger) load() error {
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open ledger: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var rec jobRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// A torn final line after a crash is expected; skip it.
+			log.Printf("ledger: skipping unreadable entry: %v", err)
+			continue
+		}
+		if rec.Path == "" || rec.ID == "" {
+			continue
+		}
+		if old, ok := l.records[rec.Path]; ok && old.ID != rec.ID {
+			delete(l.byID, old.ID)
+		}
+		r := rec
+		r.Progress = nil
+		l.records[rec.Path] = &r
+		l.byID[rec.ID] = rec.Path
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read ledger: %w", err)
+	}
+	return nil
+}
+
+// get returns a copy of the record for path.
+func (l *jobLedger) get(path string) (jobRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rec, ok := l.records[path]
+	if !ok {
+		return jobRecord{}, false
+	}
+	return *rec, true
+}
+
+// list returns copies of all records, newest first.
+func (l *jobLedger) list() []jobRecord {
+	l.mu.Lock()
+	out := make([]jobRecord, 0, len(l.records))
+	for _, rec := range l.records {
+		out = append(out, *rec)
+	}
+	l.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt.After(out[j].CreatedAt)
+	})
+	return out
+}
+
+// getByID returns a copy of the record with the given job ID.
+func (l *jobLedger) getByID(id string) (jobRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	path, ok := l.byID[id]
+	if !ok {
+		return jobRecord{}, false
+	}
+	return *l.records[path], true
+}
+
+// update applies fn to the record for path, creating it if needed, and
+// journals the result.
+func (l *jobLedger) update(path string, fn func(*jobRecord)) jobRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	rec, ok := l.records[path]
+	if !ok {
+		rec = &jobRecord{
+			ID:        newJobID(),
+			Path:      path,
+			State:     jobQueued,
+			CreatedAt: now,
+		}
+		l.records[path] = rec
+		l.byID[rec.ID] = path
+	}
+	fn(rec)
+	rec.UpdatedAt = now
+	if rec.State != jobRunning {
+		rec.Progress = nil
+	}
+
+	l.appendLocked(rec)
+	return *rec
+}
+
+// replace starts a new record with a new ID for path, dropping the record of
+// an earlier file at the same path.
+func (l *jobLedger) replace(path string, fn func(*jobRecord)) jobRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if old, ok := l.records[path]; ok {
+		delete(l.byID, old.ID)
+	}
+	now := time.Now()
+	rec := &jobRecord{
+		ID:        newJobID(),
+		Path:      path,
+		State:     jobQueued,
+		CreatedAt: now,
+	}
+	l.records[path] = rec
+	l.byID[rec.ID] = path
+	fn(rec)
+	rec.UpdatedAt = now
+
+	l.appendLocked(rec)
+	return *rec
+}
+
+// setProgress records live encode progress. Progress is not journaled, it is
+// only kept in memory while the job runs.
+func (l *jobLedger) setProgress(path string, p jobProgress) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if rec, ok := l.records[path]; ok && rec.State == jobRunning {
+		rec.Progress = &p
+	}
+}
+
+// shouldHold reports whether enqueueing path should be held back: it already
+// reached a final outcome for the file as it currently exists on disk, or it
+// is still waiting out its retry backoff.
+func (l *jobLedger) shouldHold(path string, info os.FileInfo) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rec, ok := l.records[path]
+	if !ok {
+		return false
+	}
+	// A different file dropped under the same name is new work.
+	sameFile := rec.InputSize == info.Size() && rec.SourceModTime.Equal(info.ModTime())
+
+	switch rec.State {
+	case jobSucceeded, jobSkipped, jobNotWorthIt, jobCancelled, jobQuarantined:
+		return sameFile
+	case jobFailed:
+		return sameFile && (rec.NextAttemptAt == nil || time.Now().Before(*rec.NextAttemptAt))
+	default:
+		return false
+	}
+}
+
+// savingsRatio returns the share of input size saved by finished encodes
+// with the given profile. ok is false if there are none yet.
+func (l *jobLedger) savingsRatio(profile string) (ratio float64, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var in, out int64
+	for _, rec := range l.records {
+		if rec.Profile != profile || rec.InputSize <= 0 || rec.OutputSize <= 0 {
+			continue
+		}
+		if rec.State == jobSucceeded || rec.State == jobNotWorthIt {
+			in += rec.InputSize
+			out += rec.OutputSize
+		}
+	}
+	if in == 0 {
+		return 0, false
+	}
+	return max(0, float64(in-out)/float64(in)), true
+}
+
+// prune drops records that finished longer than the TTL ago and compacts the
+// journal if anything was removed.
+func (l *jobLedger) prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.expireLocked(time.Now()) > 0 {
+		if err := l.rewriteLocked(); err != nil {
+			log.Printf("ledger: compaction failed: %v", err)
+		}
+	}
+}
+
+func (l *jobLedger) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *jobLedger) expireLocked(now time.Time) int {
+	if l.ttl <= 0 {
+		return 0
+	}
+	removed := 0
+	for path, rec := range l.records {
+		if rec.State.terminal() && now.Sub(rec.UpdatedAt) > l.ttl {
+			delete(l.records, path)
+			delete(l.byID, rec.ID)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (l *jobLedger) appendLocked(rec *jobRecord) {
+	if l.file == nil {
+		return
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("ledger: marshal %s: %v", rec.Path, err)
+		return
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		log.Printf("ledger: write %s: %v", rec.Path, err)
+		return
+	}
+	l.lines++
+
+	if l.lines > 2*len(l.records)+512 {
+		l.expireLocked(time.Now())
+		if err := l.rewriteLocked(); err != nil {
+			log.Printf("ledger: compaction failed: %v", err)
+		}
+	}
+}
+
+// rewriteLocked replaces the journal with one line per live record.
+func (l *jobLedger) rewriteLocked() error {
+	if l.path == "" {
+		return nil
+	}
+
+	tmpPath := l.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create ledger snapshot: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, rec := range l.records {
+		if err := enc.Encode(rec); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("write ledger snapshot: %w", err)
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("write ledger snapshot: %w", err)
+	}
+
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	renameErr := os.Rename(tmpPath, l.path)
+
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("reopen ledger: %w", err)
+	}
+	l.file = f
+	if renameErr != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("replace ledger: %w", renameErr)
+	}
+	l.lines = len(l.records)
+	return nil
+}
+
+func newJobID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%012x", time.Now().UnixNano()&0xffffffffffff)
+	}
+	return hex.EncodeToString(b)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"io/fs"
 	"log"
 	"os"
 	"os/signal"
@@ -14,8 +15,6 @@ import (
 	"github.com/fsnotify/fsnotify"
 )
 
-var processed sync.Map // track recently handled files to prevent loops
-
 func main() {
 	cfg, err := loadConfig()
 	if err != nil {
@@ -28,8 +27,13 @@ func main() {
 	log.Printf("  FFmpeg Binary: %s", cfg.ffmpegBinary)
 	log.Printf("  FFmpeg Command: %s", cfg.ffmpegCommand)
 	log.Printf("  Delete Source: %t", cfg.deleteSource)
+	log.Printf("  Recursive: %t", cfg.recursive)
 	log.Printf("  Processing Suffix: %s", cfg.processingSuffix)
+	log.Printf("  Processing Stale After: %v", cfg.staleAfter)
 	log.Printf("  Output Extension: %s", cfg.outputExtension)
+	for _, p := range cfg.profiles {
+		log.Printf("  Profile %s: %s -> %s (%s)", p.Name, p.command, p.OutputDir, p.Extension)
+	}
 	if cfg.httpPort == "" {
 		log.Printf("  HTTP server disabled")
 	} else {
@@ -40,15 +44,88 @@ func main() {
 	} else {
 		log.Printf("  Discord Webhook: %s", cfg.discordWebhookURL)
 	}
+	switch {
+	case cfg.slackWebhookURL != "" && cfg.slackBotToken != "":
+		log.Printf("  Slack: webhook, thumbnails to %s", cfg.slackChannel)
+	case cfg.slackWebhookURL != "":
+		log.Printf("  Slack: webhook")
+	case cfg.slackBotToken != "":
+		log.Printf("  Slack: channel %s", cfg.slackChannel)
+	}
+	if cfg.telegramToken != "" {
+		log.Printf("  Telegram: chat %s", cfg.telegramChatID)
+	}
+	if cfg.gotifyURL != "" {
+		log.Printf("  Gotify: %s", redactURL(cfg.gotifyURL))
+	}
+	if cfg.ntfyTopic != "" {
+		log.Printf("  ntfy: %s", redactURL(cfg.ntfyURL))
+	}
+	if cfg.smtpHost != "" {
+		log.Printf("  Email: %s:%s (%s) to %d recipients, per job %t", cfg.smtpHost, cfg.smtpPort, cfg.smtpTLS, len(cfg.smtpTo), cfg.smtpPerJob)
+	}
+	if cfg.webhookURL != "" {
+		log.Printf("  Webhook: %s %s (signed %t)", cfg.webhookMethod, redactURL(cfg.webhookURL), cfg.webhookSecret != "")
+	}
+	if cfg.notifySkipped {
+		log.Printf("  Notifying about skipped files")
+	}
+	if cfg.digestSchedule != nil {
+		log.Printf("  Digest: %s", cfg.digestSchedule)
+	}
 	log.Printf("  Rescan Interval: %v", cfg.rescanInterval)
 	log.Printf("  Stability Window: %v", cfg.stabilityWindow)
-	log.Printf("  Queue Size: %d", cfg.queueSize)
+	log.Printf("  Queue Size: %d (order %s)", cfg.queueSize, cfg.queueOrder)
 	log.Printf("  Max Concurrent: %d", cfg.maxConcurrent)
+	log.Printf("  Shutdown Grace Period: %v", cfg.shutdownGrace)
+	if cfg.schedule != nil {
+		log.Printf("  Schedule: %s (%s, running jobs %s)", getEnvOrEmpty("SCHEDULE"), cfg.schedule.loc, cfg.schedulePolicy)
+	}
+	log.Printf("  Max Attempts: %d (backoff %v, max %v)", cfg.maxAttempts, cfg.retryBackoff, cfg.retryBackoffMax)
+	if cfg.quarantineDir == "" {
+		log.Printf("  Quarantine disabled")
+	} else {
+		log.Printf("  Quarantine Dir: %s", cfg.quarantineDir)
+	}
+	if cfg.ledgerPath == "" {
+		log.Printf("  Job Ledger: in memory only")
+	} else {
+		log.Printf("  Job Ledger: %s (TTL %v)", cfg.ledgerPath, cfg.ledgerTTL)
+	}
 	var exts []string
 	for ext := range cfg.extensions {
 		exts = append(exts, ext)
 	}
 	log.Printf("  Video Extensions: %v", exts)
+	if cfg.validateOutput {
+		log.Printf("  Output Validation: duration ±%v, %d video / %d audio streams, full decode %t", cfg.durationTolerance, cfg.minVideoStreams, cfg.minAudioStreams, cfg.validateDecode)
+	} else {
+		log.Printf("  Output validation disabled")
+	}
+	if cfg.verifyMetric == "" {
+		log.Printf("  Quality verification disabled")
+	} else {
+		metric := resolveMetric(cfg)
+		log.Printf("  Quality Verification: %s (min score %g)", metric, minScore(cfg, metric))
+	}
+	log.Printf("  Min Savings: %.1f%% / %s (otherwise %s)", cfg.minSavingsPercent, formatFileSize(cfg.minSavingsBytes), cfg.notWorthItAction)
+	if cfg.apiToken == "" {
+		log.Printf("  API authentication disabled")
+	} else {
+		log.Printf("  API authentication: token")
+	}
+	if !cfg.uploadsEnabled {
+		log.Printf("  Uploads disabled")
+	} else if cfg.uploadMaxBytes > 0 {
+		log.Printf("  Upload Limit: %s", formatFileSize(cfg.uploadMaxBytes))
+	}
+	if len(cfg.skipCodecs) > 0 || cfg.skipBelowKbps > 0 || cfg.skipShorterThan > 0 {
+		var codecs []string
+		for codec := range cfg.skipCodecs {
+			codecs = append(codecs, codec)
+		}
+		log.Printf("  Skip Rules: codecs %v, below %d kbps, shorter than %v", codecs, cfg.skipBelowKbps, cfg.skipShorterThan)
+	}
 
 	if _, err := os.Stat(cfg.inputDir); os.IsNotExist(err) {
 		log.Fatalf("input dir does not exist: %s", cfg.inputDir)
@@ -57,58 +134,41 @@ func main() {
 		log.Fatalf("output dir does not exist: %s", cfg.outputDir)
 	}
 
+	jobs, err = openLedger(cfg.ledgerPath, cfg.ledgerTTL)
+	if err != nil {
+		log.Fatalf("open job ledger: %v", err)
+	}
+	defer jobs.close()
+
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	queue := make(chan string, cfg.queueSize)
-	var inProgress sync.Map
 	var wg sync.WaitGroup
 
-	dispatcherCtx, dispatcherCancel := context.WithCancel(ctx)
-	go func() {
-		<-dispatcherCtx.Done()
-		close(queue)
-	}()
-
-	sem := make(chan struct{}, cfg.maxConcurrent)
+	metricEvents, _ := events.subscribeReliable("metrics", 1024)
+	go metrics.consume(metricEvents)
 
+	// Notifications are sent from their own goroutine so a slow webhook does
+	// not hold up encoding. Pending ones are flushed on shutdown.
+	notifierDone := make(chan struct{})
+	notifyEvents, stopNotify := events.subscribeReliable("notify", 1024)
+	digests := make(chan digest)
+	go runDigests(ctx, cfg, digests)
 	go func() {
-		for path := range queue {
-			path := path
-			sem <- struct{}{}
-			wg.Add(1)
-			go func() {
-				defer func() {
-					<-sem
-					inProgress.Delete(path)
-					wg.Done()
-				}()
-
-				if err := processFile(ctx, cfg, path); err != nil {
-					log.Printf("process failed for %s: %v", path, err)
-				}
-			}()
-		}
+		defer close(notifierDone)
+		runNotifier(cfg, newNotifiers(cfg), notifyEvents, digests)
 	}()
 
-	enqueue := func(path string) {
-		if !shouldProcess(cfg, path) {
-			return
-		}
-		// Skip if recently handled (processed or intentionally skipped) to prevent loops
-		if _, ok := processed.Load(path); ok {
-			return
-		}
-		if _, loaded := inProgress.LoadOrStore(path, struct{}{}); loaded {
-			return
-		}
-		select {
-		case queue <- path:
-		default:
-			go func() { queue <- path }()
-		}
-	}
+	d := newDispatcher(cfg)
+	startSchedule(ctx, cfg, d)
+	go d.run(ctx)
+	go handleControlSignals(ctx, d)
+	enqueue := d.enqueue
 
+	cleanupUploads(cfg)
+	if err := recoverOrphans(cfg, enqueue); err != nil {
+		log.Printf("orphan recovery failed: %v", err)
+	}
 	if err := scanAndEnqueue(cfg, enqueue); err != nil {
 		log.Printf("initial scan failed: %v", err)
 	}
@@ -122,6 +182,9 @@ func main() {
 	if err := watcher.Add(cfg.inputDir); err != nil {
 		log.Fatalf("watch dir: %v", err)
 	}
+	if cfg.recursive {
+		watchSubdirs(cfg, watcher, cfg.inputDir)
+	}
 
 	wg.Add(1)
 	go func() {
@@ -134,6 +197,18 @@ func main() {
 				if !ok {
 					return
 				}
+				if cfg.recursive && event.Op&fsnotify.Create != 0 && isWatchableDir(cfg, event.Name) {
+					// Files may land in a new directory before its watch is
+					// registered, so scan it once after adding the watch.
+					if err := watcher.Add(event.Name); err != nil {
+						log.Printf("watch dir %s: %v", event.Name, err)
+					}
+					watchSubdirs(cfg, watcher, event.Name)
+					if err := scanDir(cfg, event.Name, enqueue); err != nil {
+						log.Printf("scan new dir %s failed: %v", event.Name, err)
+					}
+					continue
+				}
 				if event.Op&(fsnotify.Create|fsnotify.Rename|fsnotify.Write) != 0 {
 					enqueue(event.Name)
 				}
@@ -156,19 +231,29 @@ func main() {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
+				if err := recoverOrphans(cfg, enqueue); err != nil {
+					log.Printf("orphan recovery failed: %v", err)
+				}
 				if err := scanAndEnqueue(cfg, enqueue); err != nil {
 					log.Printf("periodic scan failed: %v", err)
 				}
+				jobs.prune()
+				cleanupUploads(cfg)
+				pruneThumbnails(cfg)
 			}
 		}
 	}()
 
+	// The HTTP server outlives ctx so jobs can still be watched while they
+	// finish during shutdown.
+	serverCtx, stopServer := context.WithCancel(context.Background())
+	defer stopServer()
 	serverErrs := make(chan error, 1)
 	if cfg.httpPort != "" {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			serverErrs <- runHTTPServer(ctx, cfg.httpPort)
+			serverErrs <- runHTTPServer(serverCtx, cfg.httpPort, d)
 		}()
 	} else {
 		close(serverErrs)
@@ -178,23 +263,104 @@ func main() {
 	<-ctx.Done()
 	log.Println("Shutting down...")
 
-	dispatcherCancel()
+	// A second signal skips the rest of the grace period
+	grace, skipGrace := context.WithTimeout(context.Background(), cfg.shutdownGrace)
+	defer skipGrace()
+	force := make(chan os.Signal, 1)
+	signal.Notify(force, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-force:
+			log.Println("Stopping running jobs now")
+			skipGrace()
+		case <-grace.Done():
+		}
+	}()
+
+	d.shutdown(grace)
+	stopServer()
 	wg.Wait()
+	stopNotify()
+	<-notifierDone
 }
 
 func scanAndEnqueue(cfg config, enqueue func(string)) error {
-	entries, err := os.ReadDir(cfg.inputDir)
-	if err != nil {
-		return err
+	return scanDir(cfg, cfg.inputDir, enqueue)
+}
+
+// scanDir enqueues every file in dir, descending into subdirectories when
+// recursive mode is enabled.
+func scanDir(cfg config, dir string, enqueue func(string)) error {
+	if !cfg.recursive {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			fullPath := filepath.Join(dir, entry.Name())
+			enqueue(fullPath)
+		}
+		return nil
 	}
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			log.Printf("scan %s: %v", path, err)
+			return nil
+		}
+		if d.IsDir() {
+			if path != dir && isIgnoredDir(cfg, path) {
+				return filepath.SkipDir
+			}
+			return nil
 		}
-		fullPath := filepath.Join(cfg.inputDir, entry.Name())
-		enqueue(fullPath)
+		enqueue(path)
+		return nil
+	})
+}
+
+// watchSubdirs registers watches for every directory below root.
+func watchSubdirs(cfg config, watcher *fsnotify.Watcher, root string) {
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() || path == root {
+			return nil
+		}
+		if isIgnoredDir(cfg, path) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			log.Printf("watch dir %s: %v", path, err)
+		}
+		return nil
+	})
+}
+
+func isWatchableDir(cfg config, path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+	return !isIgnoredDir(cfg, path)
+}
+
+// isIgnoredDir reports whether a subdirectory of the input tree should not be
+// descended into: hidden directories and output or quarantine dirs nested
+// inside the input.
+func isIgnoredDir(cfg config, path string) bool {
+	if strings.HasPrefix(filepath.Base(path), ".") {
+		return true
+	}
+	path = filepath.Clean(path)
+	if cfg.quarantineDir != "" && path == filepath.Clean(cfg.quarantineDir) {
+		return true
 	}
-	return nil
+	return path == filepath.Clean(cfg.outputDir)
 }
 
 func shouldProcess(cfg config, path string) bool {
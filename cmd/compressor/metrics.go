@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// encodeDurationBuckets are the upper bounds, in seconds, of the encode
+// duration histogram.
+var encodeDurationBuckets = []float64{30, 60, 120, 300, 600, 1200, 1800, 3600, 7200, 14400}
+
+type outcomeKey struct {
+	result  jobState
+	profile string
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range encodeDurationBuckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// metricsRegistry collects counters for the /metrics endpoint. Gauges are
+// read from the dispatcher when scraped.
+type metricsRegistry struct {
+	mu          sync.Mutex
+	outcomes    map[outcomeKey]uint64
+	durations   map[string]*histogram // by profile
+	inputBytes  map[string]uint64
+	outputBytes map[string]uint64
+	savedBytes  map[string]uint64
+	notifyFails map[string]uint64 // by notifier
+
+	started map[string]bool // job IDs with an encode in flight
+}
+
+var metrics = &metricsRegistry{
+	outcomes:    make(map[outcomeKey]uint64),
+	durations:   make(map[string]*histogram),
+	inputBytes:  make(map[string]uint64),
+	outputBytes: make(map[string]uint64),
+	savedBytes:  make(map[string]uint64),
+	notifyFails: make(map[string]uint64),
+	started:     make(map[string]bool),
+}
+
+// consume updates the job metrics from the event bus until ch is closed.
+func (m *metricsRegistry) consume(ch <-chan event) {
+	for e := range ch {
+		switch {
+		case e.Type == eventStarted:
+			m.mu.Lock()
+			m.started[e.Job.ID] = true
+			m.mu.Unlock()
+		case e.Type.finished():
+			m.observeJob(e.Job)
+		}
+	}
+}
+
+// observeJob records the outcome of a processFile run.
+func (m *metricsRegistry) observeJob(job jobRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Only runs that got as far as starting ffmpeg have an encode duration
+	started := m.started[job.ID]
+	delete(m.started, job.ID)
+
+	// Interrupted by shutdown, the job will run again
+	if !job.State.terminal() {
+		return
+	}
+	m.outcomes[outcomeKey{job.State, job.Profile}]++
+
+	if !started || job.StartedAt == nil || job.FinishedAt == nil {
+		return
+	}
+	h, ok := m.durations[job.Profile]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(encodeDurationBuckets))}
+		m.durations[job.Profile] = h
+	}
+	h.observe(job.FinishedAt.Sub(*job.StartedAt).Seconds())
+
+	if job.State != jobSucceeded && job.State != jobNotWorthIt {
+		return
+	}
+	m.inputBytes[job.Profile] += uint64(job.InputSize)
+	m.outputBytes[job.Profile] += uint64(job.OutputSize)
+	if job.State == jobSucceeded && job.OutputSize < job.InputSize {
+		m.savedBytes[job.Profile] += uint64(job.InputSize - job.OutputSize)
+	}
+}
+
+func (m *metricsRegistry) notificationFailed(notifier string) {
+	m.mu.Lock()
+	m.notifyFails[notifier]++
+	m.mu.Unlock()
+}
+
+// metricsHandler serves all metrics in the Prometheus text format.
+func metricsHandler(d *dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.write(w, d)
+	}
+}
+
+func (m *metricsRegistry) write(w io.Writer, d *dispatcher) {
+	queued, running := d.counts()
+	writeHeader(w, "compressor_queue_depth", "gauge", "Files waiting to be processed.")
+	fmt.Fprintf(w, "compressor_queue_depth %d\n", queued)
+	writeHeader(w, "compressor_jobs_in_flight", "gauge", "Jobs currently being processed.")
+	fmt.Fprintf(w, "compressor_jobs_in_flight %d\n", running)
+	writeHeader(w, "compressor_max_concurrent", "gauge", "Configured maximum number of concurrent jobs.")
+	fmt.Fprintf(w, "compressor_max_concurrent %d\n", d.cfg.maxConcurrent)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeHeader(w, "compressor_jobs_total", "counter", "Finished jobs by result and profile.")
+	keys := make([]outcomeKey, 0, len(m.outcomes))
+	for k := range m.outcomes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].result != keys[j].result {
+			return keys[i].result < keys[j].result
+		}
+		return keys[i].profile < keys[j].profile
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "compressor_jobs_total{result=%s,profile=%s} %d\n", labelValue(string(k.result)), labelValue(k.profile), m.outcomes[k])
+	}
+
+	writeHeader(w, "compressor_encode_duration_seconds", "histogram", "Time spent encoding, from ffmpeg start to the final result.")
+	for _, profile := range sortedKeys(m.durations) {
+		h := m.durations[profile]
+		var cumulative uint64
+		for i, bound := range encodeDurationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "compressor_encode_duration_seconds_bucket{profile=%s,le=\"%g\"} %d\n", labelValue(profile), bound, cumulative)
+		}
+		fmt.Fprintf(w, "compressor_encode_duration_seconds_bucket{profile=%s,le=\"+Inf\"} %d\n", labelValue(profile), h.count)
+		fmt.Fprintf(w, "compressor_encode_duration_seconds_sum{profile=%s} %g\n", labelValue(profile), h.sum)
+		fmt.Fprintf(w, "compressor_encode_duration_seconds_count{profile=%s} %d\n", labelValue(profile), h.count)
+	}
+
+	writeCounterVec(w, "compressor_input_bytes_total", "Bytes read from sources of completed encodes.", m.inputBytes)
+	writeCounterVec(w, "compressor_output_bytes_total", "Bytes produced by completed encodes.", m.outputBytes)
+	writeCounterVec(w, "compressor_saved_bytes_total", "Bytes saved by successful encodes.", m.savedBytes)
+
+	writeHeader(w, "compressor_notification_failures_total", "counter", "Notifications that could not be delivered.")
+	for _, notifier := range sortedKeys(m.notifyFails) {
+		fmt.Fprintf(w, "compressor_notification_failures_total{notifier=%s} %d\n", labelValue(notifier), m.notifyFails[notifier])
+	}
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeCounterVec(w io.Writer, name, help string, values map[string]uint64) {
+	writeHeader(w, name, "counter", help)
+	for _, profile := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s{profile=%s} %d\n", name, labelValue(profile), values[profile])
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelValue(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// notifyTimeout bounds a single delivery to one backend.
+const notifyTimeout = 30 * time.Second
+
+// notifyClient is shared by the HTTP based notifiers.
+var notifyClient = &http.Client{Timeout: notifyTimeout}
+
+// Notifier delivers job outcomes to a messaging service. Methods are called
+// one at a time from a single goroutine. Errors are logged and counted but
+// not retried.
+type Notifier interface {
+	// Name identifies the backend in logs and metrics.
+	Name() string
+	// Success reports an encode that was kept.
+	Success(ctx context.Context, n notification) error
+	// Failure reports a job that failed for good or was quarantined.
+	Failure(ctx context.Context, n notification) error
+	// Skip reports a file left as it was, because it did not need encoding
+	// or because the encode was not worth keeping.
+	Skip(ctx context.Context, n notification) error
+	// Digest reports a summary of the jobs finished over a period.
+	Digest(ctx context.Context, d digest) error
+}
+
+// notification is a finished job as handed to a Notifier.
+type notification struct {
+	Job jobRecord
+	// Message is the error of a failure or the reason for a skip.
+	Message string
+	// Thumbnail is the path of the JPEG thumbnail, empty if there is none.
+	Thumbnail string
+}
+
+// FileName returns the base name of the source file.
+func (n notification) FileName() string {
+	return filepath.Base(n.Job.Path)
+}
+
+// Saved returns the bytes saved by the encode, 0 if there is no output.
+func (n notification) Saved() int64 {
+	if n.Job.OutputSize <= 0 {
+		return 0
+	}
+	return n.Job.InputSize - n.Job.OutputSize
+}
+
+// Ratio returns the output size as a percentage of the input size, 0 if
+// there is no output.
+func (n notification) Ratio() float64 {
+	if n.Job.InputSize <= 0 || n.Job.OutputSize <= 0 {
+		return 0
+	}
+	return float64(n.Job.OutputSize) / float64(n.Job.InputSize) * 100
+}
+
+// Duration returns how long the job ran, or 0 if that is unknown.
+func (n notification) Duration() time.Duration {
+	if n.Job.StartedAt == nil || n.Job.FinishedAt == nil {
+		return 0
+	}
+	return n.Job.FinishedAt.Sub(*n.Job.StartedAt)
+}
+
+// digest summarises the jobs that finished between From and To.
+type digest struct {
+	From        time.Time   `json:"from"`
+	To          time.Time   `json:"to"`
+	Succeeded   int         `json:"succeeded"`
+	NotWorthIt  int         `json:"not_worth_it"`
+	Skipped     int         `json:"skipped"`
+	InputBytes  int64       `json:"input_bytes"`  // of succeeded jobs
+	OutputBytes int64       `json:"output_bytes"` // of succeeded jobs
+	Failures    []jobRecord `json:"failures"`
+	TopSavings  []jobRecord `json:"top_savings"` // succeeded jobs that saved the most, largest first
+}
+
+// Saved returns the bytes saved by all succeeded jobs.
+func (d digest) Saved() int64 {
+	return d.InputBytes - d.OutputBytes
+}
+
+// truncateRunes shortens s to at most limit runes, marking the cut with an
+// ellipsis.
+func truncateRunes(s string, limit int) string {
+	if r := []rune(s); len(r) > limit {
+		return string(r[:limit-1]) + "…"
+	}
+	return s
+}
+
+// multiNotifier fans every notification out to several backends at once.
+// A failing backend does not keep the others from being notified.
+type multiNotifier []Notifier
+
+func (m multiNotifier) Name() string { return "all" }
+
+func (m multiNotifier) Success(ctx context.Context, n notification) error {
+	return m.each(func(b Notifier) error { return b.Success(ctx, n) })
+}
+
+func (m multiNotifier) Failure(ctx context.Context, n notification) error {
+	return m.each(func(b Notifier) error { return b.Failure(ctx, n) })
+}
+
+func (m multiNotifier) Skip(ctx context.Context, n notification) error {
+	return m.each(func(b Notifier) error { return b.Skip(ctx, n) })
+}
+
+func (m multiNotifier) Digest(ctx context.Context, d digest) error {
+	return m.each(func(b Notifier) error { return b.Digest(ctx, d) })
+}
+
+func (m multiNotifier) each(send func(Notifier) error) error {
+	errs := make([]error, len(m))
+	var wg sync.WaitGroup
+	for i, b := range m {
+		wg.Add(1)
+		go func(i int, b Notifier) {
+			defer wg.Done()
+			if err := send(b); err != nil {
+				metrics.notificationFailed(b.Name())
+				errs[i] = fmt.Errorf("%s: %w", b.Name(), err)
+			}
+		}(i, b)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// redactURLError drops the request URL from an HTTP client error, for
+// backends whose URL holds a secret such as the Telegram bot token or an
+// ntfy topic.
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// newNotifiers returns the backends enabled in cfg.
+func newNotifiers(cfg config) multiNotifier {
+	var notifiers multiNotifier
+	if cfg.discordWebhookURL != "" {
+		notifiers = append(notifiers, &discordNotifier{webhookURL: cfg.discordWebhookURL})
+	}
+	if cfg.slackWebhookURL != "" || cfg.slackBotToken != "" {
+		notifiers = append(notifiers, &slackNotifier{
+			webhookURL: cfg.slackWebhookURL,
+			token:      cfg.slackBotToken,
+			channel:    cfg.slackChannel,
+			apiURL:     cfg.slackAPIURL,
+		})
+	}
+	if cfg.telegramToken != "" {
+		notifiers = append(notifiers, &telegramNotifier{
+			apiURL: cfg.telegramAPIURL,
+			token:  cfg.telegramToken,
+			chatID: cfg.telegramChatID,
+		})
+	}
+	if cfg.gotifyURL != "" {
+		notifiers = append(notifiers, &gotifyNotifier{url: cfg.gotifyURL, token: cfg.gotifyToken})
+	}
+	if cfg.ntfyTopic != "" {
+		notifiers = append(notifiers, &ntfyNotifier{url: cfg.ntfyURL, topic: cfg.ntfyTopic, token: cfg.ntfyToken})
+	}
+	if cfg.smtpHost != "" {
+		notifiers = append(notifiers, &smtpNotifier{
+			host:     cfg.smtpHost,
+			port:     cfg.smtpPort,
+			tlsMode:  cfg.smtpTLS,
+			username: cfg.smtpUsername,
+			password: cfg.smtpPassword,
+			from:     cfg.smtpFrom,
+			to:       cfg.smtpTo,
+			perJob:   cfg.smtpPerJob,
+		})
+	}
+	if cfg.webhookURL != "" {
+		notifiers = append(notifiers, &webhookNotifier{
+			url:      cfg.webhookURL,
+			method:   cfg.webhookMethod,
+			header:   cfg.webhookHeaders,
+			template: cfg.webhookTemplate,
+			secret:   cfg.webhookSecret,
+		})
+	}
+	return notifiers
+}
+
+// runNotifier hands job events and digests to n until ch is closed.
+func runNotifier(cfg config, n Notifier, ch <-chan event, digests <-chan digest) {
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			notifyEvent(cfg, n, e)
+		case d := <-digests:
+			log.Printf("digest: sending %d compressed, %d failed since %s", d.Succeeded, len(d.Failures), d.From.Format(time.RFC3339))
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			if err := n.Digest(ctx, d); err != nil {
+				log.Printf("notify digest failed: %v", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// notifyEvent reports a job event to n. Retried attempts and progress are
+// not reported, plain skips only with NOTIFY_SKIPPED.
+func notifyEvent(cfg config, n Notifier, e event) {
+	job := e.Job
+	note := notification{Job: job, Message: e.Message}
+	if job.Thumbnail {
+		note.Thumbnail = thumbnailPathFor(cfg, job.ID)
+	}
+
+	var send func(context.Context, notification) error
+	switch e.Type {
+	case eventSucceeded:
+		if job.OutputSize > 0 {
+			send = n.Success
+		}
+	case eventNotWorthIt:
+		send = n.Skip
+	case eventSkipped:
+		if cfg.notifySkipped {
+			send = n.Skip
+		}
+	case eventFailed, eventQuarantined:
+		if !e.Retrying {
+			send = n.Failure
+		}
+	}
+	if send == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	if err := send(ctx, note); err != nil {
+		log.Printf("notify %s for %s failed: %v", e.Type, job.Path, err)
+	}
+	cancel()
+}
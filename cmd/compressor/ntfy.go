@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const defaultNtfyURL = "https://ntfy.sh"
+
+// ntfy priorities for regular notifications and failures.
+const (
+	ntfyPriority       = 3
+	ntfyUrgentPriority = 4
+)
+
+// ntfyNotifier publishes messages to an ntfy topic. Successful encodes carry
+// the thumbnail as an attachment.
+type ntfyNotifier struct {
+	url   string
+	topic string
+	token string
+}
+
+func (n *ntfyNotifier) Name() string { return "ntfy" }
+
+func (n *ntfyNotifier) Success(ctx context.Context, note notification) error {
+	msg := pushSuccess(note)
+	if note.Thumbnail != "" {
+		err := n.sendAttachment(ctx, msg, note.Thumbnail, "thumbnail.jpg")
+		if err == nil {
+			return nil
+		}
+		log.Printf("ntfy: sending %s without thumbnail: %v", note.FileName(), err)
+	}
+	return n.send(ctx, msg)
+}
+
+func (n *ntfyNotifier) Skip(ctx context.Context, note notification) error {
+	return n.send(ctx, pushSkip(note))
+}
+
+func (n *ntfyNotifier) Failure(ctx context.Context, note notification) error {
+	return n.send(ctx, pushFailure(note))
+}
+
+func (n *ntfyNotifier) Digest(ctx context.Context, d digest) error {
+	return n.send(ctx, pushDigest(d))
+}
+
+func ntfyPriorityOf(msg pushMessage) int {
+	if msg.Urgent {
+		return ntfyUrgentPriority
+	}
+	return ntfyPriority
+}
+
+// send publishes msg as JSON to the root URL of the server.
+func (n *ntfyNotifier) send(ctx context.Context, msg pushMessage) error {
+	body, err := json.Marshal(map[string]any{
+		"topic":    n.topic,
+		"title":    msg.Title,
+		"message":  msg.Body,
+		"priority": ntfyPriorityOf(msg),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal ntfy message: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(n.url, "/"), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create ntfy request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return n.do(req)
+}
+
+// sendAttachment uploads the file at path to the topic, with the message in
+// headers. ntfy decodes RFC 2047 encoded header values.
+func (n *ntfyNotifier) sendAttachment(ctx context.Context, msg pushMessage, path, name string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read attachment: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSuffix(n.url, "/")+"/"+n.topic, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("create ntfy request: %w", redactURLError(err))
+	}
+	req.Header.Set("Filename", name)
+	req.Header.Set("Title", mime.BEncoding.Encode("utf-8", msg.Title))
+	req.Header.Set("Message", mime.BEncoding.Encode("utf-8", strings.ReplaceAll(msg.Body, "\n", `\n`)))
+	req.Header.Set("Priority", strconv.Itoa(ntfyPriorityOf(msg)))
+	return n.do(req)
+}
+
+func (n *ntfyNotifier) do(req *http.Request) error {
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		// The URL holds the topic, keep it out of the logs.
+		return fmt.Errorf("publish to ntfy: %w", redactURLError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("ntfy returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
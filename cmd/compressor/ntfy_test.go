@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ntfyRequest is a request received by a stand-in ntfy server.
+type ntfyRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func newNtfyServer(t *testing.T, attachOK bool) (*httptest.Server, *[]ntfyRequest) {
+	t.Helper()
+	var reqs []ntfyRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		reqs = append(reqs, ntfyRequest{method: r.Method, path: r.URL.Path, header: r.Header, body: body})
+		if r.Method == http.MethodPut && !attachOK {
+			http.Error(w, `{"error":"attachments not allowed"}`, http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, `{"id": "x"}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &reqs
+}
+
+func TestNtfyPublish(t *testing.T) {
+	srv, reqs := newNtfyServer(t, true)
+	n := &ntfyNotifier{url: srv.URL, topic: "videos", token: "tk_1"}
+
+	note := notification{Job: jobRecord{Path: "/in/a.mkv", State: jobFailed}, Message: "exit 1"}
+	if err := n.Failure(context.Background(), note); err != nil {
+		t.Fatal(err)
+	}
+	if len(*reqs) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*reqs))
+	}
+	req := (*reqs)[0]
+	if req.method != http.MethodPost || req.path != "/" {
+		t.Errorf("request = %s %s, want POST /", req.method, req.path)
+	}
+	if got := req.header.Get("Authorization"); got != "Bearer tk_1" {
+		t.Errorf("Authorization = %q", got)
+	}
+	var msg struct {
+		Topic    string `json:"topic"`
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+	}
+	if err := json.Unmarshal(req.body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Topic != "videos" || msg.Title != "❌ Compression Failed" || msg.Message != "a.mkv\nexit 1" || msg.Priority != ntfyUrgentPriority {
+		t.Errorf("message = %+v", msg)
+	}
+}
+
+func TestNtfySuccessWithAttachment(t *testing.T) {
+	srv, reqs := newNtfyServer(t, true)
+	n := &ntfyNotifier{url: srv.URL + "/", topic: "videos"}
+
+	note := notification{
+		Job:       jobRecord{Path: "/in/ä.mkv", State: jobSucceeded, InputSize: 4 << 20, OutputSize: 1 << 20},
+		Thumbnail: writeThumbnail(t),
+	}
+	if err := n.Success(context.Background(), note); err != nil {
+		t.Fatal(err)
+	}
+	if len(*reqs) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*reqs))
+	}
+	req := (*reqs)[0]
+	if req.method != http.MethodPut || req.path != "/videos" || string(req.body) != "jpeg" {
+		t.Errorf("request = %s %s %q, want the thumbnail PUT to /videos", req.method, req.path, req.body)
+	}
+	if got := req.header.Get("Authorization"); got != "" {
+		t.Errorf("Authorization = %q without a token", got)
+	}
+	var dec mime.WordDecoder
+	title, err := dec.DecodeHeader(req.header.Get("Title"))
+	if err != nil || title != "✅ Compression Successful" {
+		t.Errorf("Title = %q (%v)", title, err)
+	}
+	message, err := dec.DecodeHeader(req.header.Get("Message"))
+	if err != nil || !strings.HasPrefix(message, `ä.mkv\n4.0 MB → 1.0 MB`) {
+		t.Errorf("Message = %q (%v)", message, err)
+	}
+	if got := req.header.Get("Filename"); got != "thumbnail.jpg" {
+		t.Errorf("Filename = %q", got)
+	}
+}
+
+func TestNtfySuccessFallsBackToMessage(t *testing.T) {
+	srv, reqs := newNtfyServer(t, false)
+	n := &ntfyNotifier{url: srv.URL, topic: "videos"}
+
+	note := notification{
+		Job:       jobRecord{Path: "/in/a.mkv", State: jobSucceeded, InputSize: 4 << 20, OutputSize: 1 << 20},
+		Thumbnail: writeThumbnail(t),
+	}
+	if err := n.Success(context.Background(), note); err != nil {
+		t.Fatalf("Success() = %v, want the message sent without thumbnail", err)
+	}
+	if len(*reqs) != 2 || (*reqs)[0].method != http.MethodPut || (*reqs)[1].method != http.MethodPost {
+		t.Fatalf("requests = %+v, want PUT then POST", *reqs)
+	}
+}
+
+func TestNtfyErrorHidesTopic(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	n := &ntfyNotifier{url: srv.URL, topic: "secret-topic"}
+
+	note := notification{Job: jobRecord{Path: "/in/a.mkv", State: jobSucceeded}, Thumbnail: writeThumbnail(t)}
+	err := n.Success(context.Background(), note)
+	if err == nil {
+		t.Fatal("Success() succeeded against a closed server")
+	}
+	if strings.Contains(err.Error(), "secret-topic") {
+		t.Errorf("error leaks the topic: %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// probeResult is the subset of ffprobe's output the compressor acts on.
+type probeResult struct {
+	Container string        `json:"container"`
+	Duration  float64       `json:"duration"`
+	Bitrate   int64         `json:"bitrate"`
+	Size      int64         `json:"size"`
+	Streams   []probeStream `json:"streams"`
+}
+
+type probeStream struct {
+	Index     int     `json:"index"`
+	Type      string  `json:"type"`
+	Codec     string  `json:"codec"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	FrameRate float64 `json:"frame_rate,omitempty"`
+	Bitrate   int64   `json:"bitrate,omitempty"`
+	Channels  int     `json:"channels,omitempty"`
+}
+
+// video returns the first video stream, or nil if there is none.
+func (p *probeResult) video() *probeStream {
+	for i := range p.Streams {
+		if p.Streams[i].Type == "video" {
+			return &p.Streams[i]
+		}
+	}
+	return nil
+}
+
+// streamCount returns the number of streams of the given codec type.
+func (p *probeResult) streamCount(kind string) int {
+	n := 0
+	for _, s := range p.Streams {
+		if s.Type == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// kbps returns the overall bitrate in kbit/s, derived from size and duration
+// when the container does not report one.
+func (p *probeResult) kbps() int64 {
+	if p.Bitrate > 0 {
+		return p.Bitrate / 1000
+	}
+	if p.Duration > 0 && p.Size > 0 {
+		return int64(float64(p.Size*8) / p.Duration / 1000)
+	}
+	return 0
+}
+
+func ffprobeBinary(cfg config) string {
+	// Assume ffprobe is available alongside ffmpeg
+	return strings.Replace(cfg.ffmpegBinary, "ffmpeg", "ffprobe", 1)
+}
+
+func probeVideo(ctx context.Context, cfg config, videoPath string) (*probeResult, error) {
+	args := []string{
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		videoPath,
+	}
+
+	cmd := exec.CommandContext(ctx, ffprobeBinary(cfg), args...)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var raw struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+			BitRate    string `json:"bit_rate"`
+			Size       string `json:"size"`
+		} `json:"format"`
+		Streams []struct {
+			Index        int    `json:"index"`
+			CodecType    string `json:"codec_type"`
+			CodecName    string `json:"codec_name"`
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+			AvgFrameRate string `json:"avg_frame_rate"`
+			RFrameRate   string `json:"r_frame_rate"`
+			BitRate      string `json:"bit_rate"`
+			Channels     int    `json:"channels"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &raw); err != nil {
+		return nil, fmt.Errorf("parse ffprobe output: %w", err)
+	}
+
+	result := &probeResult{
+		Container: raw.Format.FormatName,
+		Duration:  parseFloat(raw.Format.Duration),
+		Bitrate:   parseInt(raw.Format.BitRate),
+		Size:      parseInt(raw.Format.Size),
+	}
+	for _, s := range raw.Streams {
+		fps := parseFrameRate(s.AvgFrameRate)
+		if fps == 0 {
+			fps = parseFrameRate(s.RFrameRate)
+		}
+		result.Streams = append(result.Streams, probeStream{
+			Index:     s.Index,
+			Type:      s.CodecType,
+			Codec:     s.CodecName,
+			Width:     s.Width,
+			Height:    s.Height,
+			FrameRate: fps,
+			Bitrate:   parseInt(s.BitRate),
+			Channels:  s.Channels,
+		})
+	}
+	return result, nil
+}
+
+// skipReason returns why a source does not need encoding according to the
+// configured skip rules, or an empty string if it should be encoded.
+func skipReason(cfg config, p *probeResult) string {
+	if v := p.video(); v != nil {
+		if _, ok := cfg.skipCodecs[strings.ToLower(v.Codec)]; ok {
+			return fmt.Sprintf("already %s", v.Codec)
+		}
+	}
+	if cfg.skipBelowKbps > 0 {
+		if kbps := p.kbps(); kbps > 0 && kbps < int64(cfg.skipBelowKbps) {
+			return fmt.Sprintf("bitrate %d kbps below %d kbps", kbps, cfg.skipBelowKbps)
+		}
+	}
+	if cfg.skipShorterThan > 0 && p.Duration > 0 {
+		if d := time.Duration(p.Duration * float64(time.Second)); d < cfg.skipShorterThan {
+			return fmt.Sprintf("duration %v shorter than %v", d.Round(time.Second), cfg.skipShorterThan)
+		}
+	}
+	return ""
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func parseInt(s string) int64 {
+	i, _ := strconv.ParseInt(s, 10, 64)
+	return i
+}
+
+// parseFrameRate parses ffprobe rationals such as "30000/1001".
+func parseFrameRate(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		return parseFloat(s)
+	}
+	d := parseFloat(den)
+	if d == 0 {
+		return 0
+	}
+	return parseFloat(num) / d
+}
@@ -2,60 +2,137 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"al.essio.dev/pkg/shellescape"
 	"github.com/mattn/go-shellwords"
 )
 
-func processFile(ctx context.Context, cfg config, originalPath string) error {
-	// Get original file size for Discord notifications
+func processFile(ctx context.Context, cfg config, originalPath string) (err error) {
+	defer func() {
+		if err != nil {
+			// Make an interruption visible in the error, e.g. for a cancel
+			// that surfaced as a failed ffmpeg run.
+			if cause := context.Cause(ctx); cause != nil && !errors.Is(err, cause) {
+				err = fmt.Errorf("%w: %w", cause, err)
+			}
+			markFailed(cfg, originalPath, err)
+		}
+	}()
+
+	if err := waitForStability(ctx, originalPath, cfg.stabilityWindow); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			markSkipped(originalPath, nil, "source disappeared")
+			return nil
+		}
+		return fmt.Errorf("stability check: %w", err)
+	}
+	publishJob(eventStable, originalPath, "")
+
+	// Get original file size for Discord notifications and the job ledger
 	originalInfo, err := os.Stat(originalPath)
 	if err != nil {
 		return fmt.Errorf("stat original file: %w", err)
 	}
 	originalSize := originalInfo.Size()
 
-	if err := waitForStability(ctx, originalPath, cfg.stabilityWindow); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
+	// Probe the source so files that are already efficient are left alone.
+	probe, err := probeVideo(ctx, cfg, originalPath)
+	if err != nil {
+		log.Printf("probe %s failed, skip rules not applied: %v", originalPath, err)
+	} else {
+		jobs.update(originalPath, func(j *jobRecord) { j.Probe = probe })
+		if reason := skipReason(cfg, probe); reason != "" {
+			log.Printf("skip %s: %s", originalPath, reason)
+			markSkipped(originalPath, originalInfo, reason)
 			return nil
 		}
-		sendDiscordFailure(cfg.discordWebhookURL, originalPath, fmt.Sprintf("stability check: %v", err))
-		return fmt.Errorf("stability check: %w", err)
+	}
+
+	prof := selectProfile(cfg, originalPath, originalSize, probe)
+	if job, ok := jobs.get(originalPath); ok && job.PinnedProfile != "" {
+		// Chosen at upload time, overrides the match rules
+		if p, ok := findProfile(cfg, job.PinnedProfile); ok {
+			prof = p
+		} else {
+			log.Printf("pinned profile %q for %s no longer exists, using %s", job.PinnedProfile, originalPath, prof.Name)
+		}
 	}
 
 	// If the intended output already exists, do not queue/process this input.
-	outputPath, err := buildOutputPath(cfg, originalPath)
+	outputPath, err := buildOutputPath(cfg, prof, originalPath)
 	if err != nil {
 		if errors.Is(err, os.ErrExist) {
 			log.Printf("skip %s: output already exists: %v", originalPath, err)
-			processed.Store(originalPath, time.Now())
+			markSkipped(originalPath, originalInfo, "output already exists")
 			return nil
 		}
-		sendDiscordFailure(cfg.discordWebhookURL, originalPath, fmt.Sprintf("build output path: %v", err))
-		return err
+		return fmt.Errorf("build output path: %w", err)
 	}
 
 	processingPath := originalPath + cfg.processingSuffix
+	markerPath := markerPathFor(processingPath)
+	marker := newProcessingMarker(originalPath, outputPath)
+	if err := writeMarker(markerPath, marker); err != nil {
+		return fmt.Errorf("write processing marker: %w", err)
+	}
 	if err := os.Rename(originalPath, processingPath); err != nil {
+		removeMarker(markerPath)
 		if errors.Is(err, os.ErrNotExist) {
+			markSkipped(originalPath, nil, "source disappeared")
 			return nil
 		}
-		sendDiscordFailure(cfg.discordWebhookURL, originalPath, fmt.Sprintf("rename for processing: %v", err))
 		return fmt.Errorf("rename for processing: %w", err)
 	}
 
+	job := jobs.update(originalPath, func(j *jobRecord) {
+		now := time.Now()
+		j.State = jobRunning
+		j.Attempts++
+		j.NextAttemptAt = nil
+		j.Error = ""
+		j.Reason = ""
+		j.InputSize = originalSize
+		j.SourceModTime = originalInfo.ModTime()
+		j.OutputPath = outputPath
+		j.Profile = prof.Name
+		j.StartedAt = &now
+		j.FinishedAt = nil
+		// Results of an earlier run at this path
+		j.OutputSize = 0
+		j.Quality = nil
+		j.QuarantinePath = ""
+		j.Thumbnail = false
+	})
+	events.publish(event{Type: eventStarted, Job: job})
+
+	stopHeartbeat := startHeartbeat(markerPath, marker, cfg.staleAfter/4)
+
 	success := false
+	var giveUp error
 	defer func() {
+		defer removeMarker(markerPath)
+		stopHeartbeat()
+
+		if giveUp != nil && cfg.quarantineDir != "" {
+			dest, err := quarantineFile(cfg, processingPath, originalPath, job, giveUp)
+			if err == nil {
+				log.Printf("quarantined %s -> %s", originalPath, dest)
+				jobs.update(originalPath, func(j *jobRecord) { j.QuarantinePath = dest })
+				return
+			}
+			log.Printf("quarantine %s failed: %v", originalPath, err)
+		}
+
 		if success {
 			if cfg.deleteSource {
 				if err := os.Remove(processingPath); err != nil && !errors.Is(err, os.ErrNotExist) {
@@ -75,47 +152,204 @@ func processFile(ctx context.Context, cfg config, originalPath string) error {
 		}
 	}()
 
-	if err := runFFMPEG(ctx, cfg, processingPath, outputPath); err != nil {
-		sendDiscordFailure(cfg.discordWebhookURL, originalPath, err.Error())
+	// fail discards the output of an unusable encode and either leaves the
+	// job to be retried or gives up on it.
+	fail := func(err error) error {
 		if removeErr := os.Remove(outputPath); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
 			log.Printf("remove partial output %s failed: %v", outputPath, removeErr)
 		}
-		return err
+		if ctx.Err() != nil {
+			// Interrupted rather than failed, does not count toward giving up
+			return err
+		}
+		if errors.As(err, new(terminalError)) {
+			log.Printf("attempt %d for %s failed, not retrying: %v", job.Attempts, originalPath, err)
+			return err
+		}
+		if job.Attempts < cfg.maxAttempts {
+			log.Printf("attempt %d/%d for %s failed, retrying in %v: %v", job.Attempts, cfg.maxAttempts, originalPath, retryBackoff(cfg, job.Attempts), err)
+			return retryingError{err}
+		}
+		giveUp = err
+		return fmt.Errorf("%w (%d): %w", errMaxAttempts, job.Attempts, err)
 	}
 
-	success = true
-	log.Printf("processed %s -> %s", originalPath, outputPath)
+	log.Printf("profile %s for %s", prof.Name, originalPath)
 
-	// Send Discord success notification
+	var duration float64
+	if probe != nil {
+		duration = probe.Duration
+	}
+	lastLogged := -1
+	onProgress := func(p jobProgress) {
+		jobs.setProgress(originalPath, p)
+		publishJob(eventProgress, originalPath, "")
+		if step := int(p.Percent) / 10; step > lastLogged && p.Percent < 100 {
+			lastLogged = step
+			log.Printf("progress %s: %.0f%% (speed %.2fx, ETA %v)", filepath.Base(originalPath), p.Percent, p.Speed, (time.Duration(p.ETA) * time.Second).Round(time.Second))
+		}
+	}
+
+	if err := runFFMPEG(ctx, cfg, prof, processingPath, outputPath, duration, onProgress); err != nil {
+		return fail(err)
+	}
+
+	// ffmpeg can exit 0 and still leave a truncated file behind
+	if cfg.validateOutput {
+		if err := validateOutput(ctx, cfg, outputPath, probe); err != nil {
+			return fail(terminalError{err})
+		}
+	}
+
+	var compressedSize int64
 	if compressedInfo, err := os.Stat(outputPath); err == nil {
-		compressedSize := compressedInfo.Size()
-
-		// Generate thumbnail for Discord webhook
-		thumbnailPath := ""
-		if cfg.discordWebhookURL != "" {
-			// Generate unique thumbnail path in /tmp
-			baseName := filepath.Base(strings.TrimSuffix(originalPath, filepath.Ext(originalPath)))
-			thumbnailPath = fmt.Sprintf("/tmp/compressor_thumb_%s_%d.jpg", baseName, time.Now().UnixNano())
-			if thumbErr := generateThumbnail(ctx, cfg, outputPath, thumbnailPath); thumbErr != nil {
-				log.Printf("Failed to generate thumbnail: %v", thumbErr)
-				thumbnailPath = "" // Continue without thumbnail
-			}
+		compressedSize = compressedInfo.Size()
+	}
+
+	if reason := savingsShortfall(cfg, originalSize, compressedSize); reason != "" {
+		keptPath, err := keepOriginal(cfg, processingPath, originalPath, outputPath)
+		if err != nil {
+			log.Printf("not worth it: %s: %v", originalPath, err)
 		}
+		// Only a copy of the original in the output dir stands in for a
+		// successful encode; otherwise the source is restored untouched.
+		success = keptPath != ""
+		log.Printf("not worth it: %s: %s", originalPath, reason)
+		done := jobs.update(originalPath, func(j *jobRecord) {
+			now := time.Now()
+			j.State = jobNotWorthIt
+			j.Reason = reason
+			j.OutputPath = keptPath
+			j.OutputSize = compressedSize
+			j.FinishedAt = &now
+		})
+		events.publish(event{Type: eventNotWorthIt, Job: done, Message: reason})
+		return nil
+	}
 
-		sendDiscordSuccessWithThumbnail(cfg.discordWebhookURL, originalPath, originalSize, compressedSize, thumbnailPath)
+	var quality *qualityResult
+	if cfg.verifyMetric != "" {
+		q, err := measureQuality(ctx, cfg, processingPath, outputPath, probe)
+		if err != nil {
+			// A broken filter or model, not the file's fault. The output is
+			// unverified, so the source is kept rather than quarantined.
+			return fail(terminalError{err})
+		}
+		quality = &q
+		if threshold := minScore(cfg, q.Metric); q.Score < threshold {
+			return fail(terminalError{fmt.Errorf("quality %s below minimum %g", q, threshold)})
+		}
+		log.Printf("quality %s: %s", originalPath, q)
+	}
 
-		// Clean up thumbnail file
-		if thumbnailPath != "" {
-			if err := os.Remove(thumbnailPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-				log.Printf("Failed to clean up thumbnail %s: %v", thumbnailPath, err)
-			}
+	success = true
+	log.Printf("processed %s -> %s", originalPath, outputPath)
+
+	// The thumbnail is kept for the dashboard and notifications
+	thumbnail := false
+	if compressedSize > 0 {
+		if thumbErr := generateThumbnail(ctx, cfg, outputPath, thumbnailPathFor(cfg, job.ID)); thumbErr != nil {
+			log.Printf("Failed to generate thumbnail: %v", thumbErr)
+		} else {
+			thumbnail = true
 		}
 	}
 
-	processed.Store(originalPath, time.Now())
+	done := jobs.update(originalPath, func(j *jobRecord) {
+		now := time.Now()
+		j.State = jobSucceeded
+		j.OutputSize = compressedSize
+		j.Quality = quality
+		j.Thumbnail = thumbnail
+		j.FinishedAt = &now
+	})
+	events.publish(event{Type: eventSucceeded, Job: done})
+
 	return nil
 }
 
+// markSkipped records that path was intentionally not encoded. info, when
+// known, identifies the file so it is not looked at again.
+func markSkipped(path string, info os.FileInfo, reason string) {
+	job := jobs.update(path, func(j *jobRecord) {
+		now := time.Now()
+		j.State = jobSkipped
+		j.Reason = reason
+		j.FinishedAt = &now
+		if info != nil {
+			j.InputSize = info.Size()
+			j.SourceModTime = info.ModTime()
+		}
+	})
+	events.publish(event{Type: eventSkipped, Job: job, Message: reason})
+}
+
+// retryingError wraps a failed encode attempt that will be tried again.
+type retryingError struct{ error }
+
+func (e retryingError) Unwrap() error { return e.error }
+
+// terminalError wraps a failure that another attempt would only repeat, such
+// as an output that fails validation or the quality minimum. The job fails
+// right away and the source stays where it is.
+type terminalError struct{ error }
+
+func (e terminalError) Unwrap() error { return e.error }
+
+// markFailed records a failure and schedules the next attempt, unless the job
+// has used up its attempts.
+func markFailed(cfg config, path string, err error) {
+	job := jobs.update(path, func(j *jobRecord) {
+		now := time.Now()
+		j.Error = err.Error()
+		j.FinishedAt = &now
+		if errors.Is(err, errJobCancelled) {
+			j.State = jobCancelled
+			j.NextAttemptAt = nil
+			return
+		}
+		if errors.Is(err, errShutdown) {
+			// Not the file's fault, so the attempt does not count
+			if j.State == jobRunning && j.Attempts > 0 {
+				j.Attempts--
+			}
+			j.State = jobQueued
+			j.NextAttemptAt = nil
+			j.FinishedAt = nil
+			return
+		}
+		if errors.Is(err, errMaxAttempts) {
+			// Quarantined only if the source was actually moved, with
+			// QUARANTINE_DIR=off it stays in place as a final failure.
+			j.State = jobFailed
+			if j.QuarantinePath != "" {
+				j.State = jobQuarantined
+			}
+			j.NextAttemptAt = nil
+			return
+		}
+		j.State = jobFailed
+		if errors.As(err, new(terminalError)) {
+			j.NextAttemptAt = nil
+			return
+		}
+		next := now.Add(retryBackoff(cfg, j.Attempts))
+		j.NextAttemptAt = &next
+	})
+
+	e := event{Type: eventFailed, Job: job, Message: err.Error()}
+	switch job.State {
+	case jobCancelled:
+		e.Type = eventCancelled
+	case jobQuarantined:
+		e.Type = eventQuarantined
+	default:
+		// An interrupted run is picked up again like a retried attempt
+		e.Retrying = errors.As(err, new(retryingError)) || errors.Is(err, errShutdown)
+	}
+	events.publish(e)
+}
+
 func waitForStability(ctx context.Context, path string, stableFor time.Duration) error {
 	if stableFor <= 0 {
 		return nil
@@ -158,9 +392,32 @@ func waitForStability(ctx context.Context, path string, stableFor time.Duration)
 	}
 }
 
-func buildOutputPath(cfg config, originalPath string) (string, error) {
+func buildOutputPath(cfg config, prof profile, originalPath string) (string, error) {
+	candidate, err := outputPathFor(cfg, prof, originalPath)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(candidate), 0o755); err != nil {
+		return "", fmt.Errorf("ensure output dir: %w", err)
+	}
+
+	if _, err := os.Stat(candidate); err == nil {
+		return "", fmt.Errorf("output already exists: %s: %w", candidate, os.ErrExist)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("stat output candidate: %w", err)
+	}
+
+	return candidate, nil
+}
+
+// outputPathFor returns where prof writes the output for originalPath,
+// without checking whether it exists.
+func outputPathFor(cfg config, prof profile, originalPath string) (string, error) {
 	base := strings.TrimSuffix(filepath.Base(originalPath), filepath.Ext(originalPath))
-	ext := cfg.outputExtension
+	ext := prof.Extension
+	if ext == "" {
+		ext = cfg.outputExtension
+	}
 	if ext == "" {
 		ext = filepath.Ext(originalPath)
 	}
@@ -168,26 +425,35 @@ func buildOutputPath(cfg config, originalPath string) (string, error) {
 		ext = "." + ext
 	}
 
-	if err := os.MkdirAll(cfg.outputDir, 0o755); err != nil {
-		return "", fmt.Errorf("ensure output dir: %w", err)
+	outputRoot := prof.OutputDir
+	if outputRoot == "" {
+		outputRoot = cfg.outputDir
 	}
 
-	candidate := filepath.Join(cfg.outputDir, base+ext)
-	if _, err := os.Stat(candidate); err == nil {
-		return "", fmt.Errorf("output already exists: %s: %w", candidate, os.ErrExist)
-	} else if !errors.Is(err, os.ErrNotExist) {
-		return "", fmt.Errorf("stat output candidate: %w", err)
+	outputDir := outputRoot
+	if cfg.recursive {
+		// Mirror the source's location below the input dir
+		rel, err := filepath.Rel(cfg.inputDir, filepath.Dir(originalPath))
+		if err != nil {
+			return "", fmt.Errorf("relative input path: %w", err)
+		}
+		if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("%s is outside of the input dir", originalPath)
+		}
+		outputDir = filepath.Join(outputRoot, rel)
 	}
 
-	return candidate, nil
+	return filepath.Join(outputDir, base+ext), nil
 }
 
-func runFFMPEG(ctx context.Context, cfg config, inputPath, outputPath string) error {
+// runFFMPEG encodes inputPath with the profile's command. duration is the
+// source duration in seconds and onProgress, if set, receives live progress.
+func runFFMPEG(ctx context.Context, cfg config, prof profile, inputPath, outputPath string, duration float64, onProgress func(jobProgress)) error {
 	if err := os.MkdirAll(filepath.Dir(outputPath), 0o755); err != nil {
 		return fmt.Errorf("prepare output dir: %w", err)
 	}
 
-	substituted := strings.ReplaceAll(cfg.ffmpegCommand, "{{input}}", shellescape.Quote(inputPath))
+	substituted := strings.ReplaceAll(prof.command, "{{input}}", shellescape.Quote(inputPath))
 	substituted = strings.ReplaceAll(substituted, "{{output}}", shellescape.Quote(outputPath))
 
 	args, err := shellwords.Parse(substituted)
@@ -195,19 +461,86 @@ func runFFMPEG(ctx context.Context, cfg config, inputPath, outputPath string) er
 		return fmt.Errorf("parse ffmpeg args: %w", err)
 	}
 
+	// Machine readable progress goes to stdout, leaving stderr for logs.
+	args = append([]string{"-progress", "pipe:1"}, args...)
+
+	stderrTail := &tailBuffer{max: 4096}
+	progressReader, progressWriter := io.Pipe()
+	parsed := make(chan struct{})
+	go func() {
+		defer close(parsed)
+		parseProgress(progressReader, duration, func(p jobProgress) {
+			if onProgress != nil {
+				onProgress(p)
+			}
+		})
+	}()
+
 	cmd := exec.CommandContext(ctx, cfg.ffmpegBinary, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = progressWriter
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderrTail)
 	cmd.Env = os.Environ()
 
 	log.Printf("ffmpeg start: %s -> %s", inputPath, outputPath)
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ffmpeg failed: %w", err)
+	// Registered so the schedule can suspend the encode
+	if err = cmd.Start(); err == nil {
+		encoders.add(cmd.Process)
+		err = cmd.Wait()
+		encoders.remove(cmd.Process)
+	}
+	progressWriter.Close()
+	<-parsed
+
+	if err != nil {
+		ffErr := &ffmpegError{Err: err, ExitCode: -1, StderrTail: stderrTail.String()}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			ffErr.ExitCode = exitErr.ExitCode()
+		}
+		return ffErr
 	}
 	return nil
 }
 
+// ffmpegError carries the exit code and the end of stderr of a failed run.
+type ffmpegError struct {
+	Err        error
+	ExitCode   int
+	StderrTail string
+}
+
+func (e *ffmpegError) Error() string {
+	return fmt.Sprintf("ffmpeg failed: %v", e.Err)
+}
+
+func (e *ffmpegError) Unwrap() error {
+	return e.Err
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.max; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
+
 func generateThumbnail(ctx context.Context, cfg config, videoPath, thumbnailPath string) error {
 	if err := os.MkdirAll(filepath.Dir(thumbnailPath), 0o755); err != nil {
 		return fmt.Errorf("prepare thumbnail dir: %w", err)
@@ -250,38 +583,12 @@ func generateThumbnail(ctx context.Context, cfg config, videoPath, thumbnailPath
 }
 
 func getVideoDuration(ctx context.Context, cfg config, videoPath string) (float64, error) {
-	// Use ffprobe to get video duration
-	// Assume ffprobe is available alongside ffmpeg
-	ffprobePath := strings.Replace(cfg.ffmpegBinary, "ffmpeg", "ffprobe", 1)
-
-	args := []string{
-		"-v", "quiet",
-		"-print_format", "json",
-		"-show_format",
-		videoPath,
-	}
-
-	cmd := exec.CommandContext(ctx, ffprobePath, args...)
-	output, err := cmd.Output()
+	probe, err := probeVideo(ctx, cfg, videoPath)
 	if err != nil {
-		return 0, fmt.Errorf("ffprobe failed: %w", err)
+		return 0, err
 	}
-
-	// Parse the JSON output to extract duration
-	var probeResult struct {
-		Format struct {
-			Duration string `json:"duration"`
-		} `json:"format"`
-	}
-
-	if err := json.Unmarshal(output, &probeResult); err != nil {
-		return 0, fmt.Errorf("parse ffprobe output: %w", err)
+	if probe.Duration <= 0 {
+		return 0, fmt.Errorf("parse duration: no duration reported")
 	}
-
-	duration, err := strconv.ParseFloat(probeResult.Format.Duration, 64)
-	if err != nil {
-		return 0, fmt.Errorf("parse duration: %w", err)
-	}
-
-	return duration, nil
+	return probe.Duration, nil
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultProfileName = "default"
+
+// profile is a named encoding setup. Profiles are tried in the order they are
+// configured and the first one whose match rules all hold is used.
+type profile struct {
+	Name       string       `json:"name"`
+	Command    string       `json:"command"`
+	CommandCPU string       `json:"command_cpu,omitempty"`
+	Extension  string       `json:"extension,omitempty"`
+	OutputDir  string       `json:"output_dir,omitempty"`
+	Priority   int          `json:"priority,omitempty"`
+	Match      profileMatch `json:"match"`
+
+	// command is the template in effect on this host, picked from Command
+	// and CommandCPU depending on GPU availability.
+	command string
+}
+
+// profileMatch holds the rules for selecting a profile. Empty fields are
+// ignored, so a profile without any rules matches every file.
+type profileMatch struct {
+	Glob           string   `json:"glob,omitempty"`
+	Subdir         string   `json:"subdir,omitempty"`
+	MinSize        int64    `json:"min_size,omitempty"`
+	MaxSize        int64    `json:"max_size,omitempty"`
+	Codecs         []string `json:"codecs,omitempty"`
+	MinHeight      int      `json:"min_height,omitempty"`
+	MaxHeight      int      `json:"max_height,omitempty"`
+	MaxFrameRate   float64  `json:"max_frame_rate,omitempty"`
+	MinBitrateKbps int64    `json:"min_bitrate_kbps,omitempty"`
+	MaxBitrateKbps int64    `json:"max_bitrate_kbps,omitempty"`
+}
+
+type profilesFile struct {
+	Profiles []profile `json:"profiles"`
+}
+
+// loadProfiles reads the profiles file at path. A profile named "default"
+// replaces the one built from the environment.
+func loadProfiles(path string, gpuAvailable bool) ([]profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read profiles: %w", err)
+	}
+	var file profilesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse profiles %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for i := range file.Profiles {
+		p := &file.Profiles[i]
+		if p.Name == "" {
+			return nil, fmt.Errorf("profile %d has no name", i+1)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("duplicate profile %q", p.Name)
+		}
+		seen[p.Name] = true
+
+		p.command = p.Command
+		if !gpuAvailable && p.CommandCPU != "" {
+			p.command = p.CommandCPU
+		}
+		if !strings.Contains(p.command, "{{input}}") || !strings.Contains(p.command, "{{output}}") {
+			return nil, fmt.Errorf("profile %q: command must contain {{input}} and {{output}}", p.Name)
+		}
+		if p.Extension != "" && !strings.HasPrefix(p.Extension, ".") {
+			p.Extension = "." + p.Extension
+		}
+		for j, codec := range p.Match.Codecs {
+			p.Match.Codecs[j] = strings.ToLower(codec)
+		}
+		if p.Match.Glob != "" {
+			if _, err := filepath.Match(p.Match.Glob, ""); err != nil {
+				return nil, fmt.Errorf("profile %q: invalid glob: %w", p.Name, err)
+			}
+		}
+		p.Match.Subdir = filepath.Clean(p.Match.Subdir)
+		if p.Match.Subdir == "." {
+			p.Match.Subdir = ""
+		}
+	}
+	return file.Profiles, nil
+}
+
+// findProfile returns the profile with the given name.
+func findProfile(cfg config, name string) (profile, bool) {
+	if name == cfg.defaultProfile.Name {
+		return cfg.defaultProfile, true
+	}
+	for _, p := range cfg.profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return profile{}, false
+}
+
+// selectProfile picks the profile for a source file. probe may be nil, in
+// which case rules on probed properties never match.
+func selectProfile(cfg config, path string, size int64, probe *probeResult) profile {
+	for _, p := range cfg.profiles {
+		if p.Name != defaultProfileName && p.Match.matches(cfg, path, size, probe) {
+			return p
+		}
+	}
+	return cfg.defaultProfile
+}
+
+func (m profileMatch) matches(cfg config, path string, size int64, probe *probeResult) bool {
+	if m.Glob != "" {
+		if ok, _ := filepath.Match(m.Glob, filepath.Base(path)); !ok {
+			return false
+		}
+	}
+	if m.Subdir != "" {
+		rel, err := filepath.Rel(cfg.inputDir, filepath.Dir(path))
+		if err != nil || (rel != m.Subdir && !strings.HasPrefix(rel, m.Subdir+string(filepath.Separator))) {
+			return false
+		}
+	}
+	if m.MinSize > 0 && size < m.MinSize {
+		return false
+	}
+	if m.MaxSize > 0 && size > m.MaxSize {
+		return false
+	}
+
+	if len(m.Codecs) == 0 && m.MinHeight == 0 && m.MaxHeight == 0 && m.MaxFrameRate == 0 && m.MinBitrateKbps == 0 && m.MaxBitrateKbps == 0 {
+		return true
+	}
+	if probe == nil {
+		return false
+	}
+	video := probe.video()
+	if video == nil {
+		return false
+	}
+	if len(m.Codecs) > 0 {
+		found := false
+		for _, codec := range m.Codecs {
+			if codec == strings.ToLower(video.Codec) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if m.MinHeight > 0 && video.Height < m.MinHeight {
+		return false
+	}
+	if m.MaxHeight > 0 && video.Height > m.MaxHeight {
+		return false
+	}
+	if m.MaxFrameRate > 0 && video.FrameRate > m.MaxFrameRate {
+		return false
+	}
+	kbps := probe.kbps()
+	if m.MinBitrateKbps > 0 && kbps < m.MinBitrateKbps {
+		return false
+	}
+	if m.MaxBitrateKbps > 0 && kbps > m.MaxBitrateKbps {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jobProgress is the latest state reported by ffmpeg's -progress output.
+type jobProgress struct {
+	Frame       int64     `json:"frame"`
+	OutTime     float64   `json:"out_time"`
+	Speed       float64   `json:"speed"`
+	BitrateKbps float64   `json:"bitrate_kbps"`
+	Percent     float64   `json:"percent"`
+	ETA         float64   `json:"eta_seconds"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// parseProgress reads ffmpeg -progress key=value blocks from r and calls
+// update once per block. duration is the source duration in seconds and is
+// used to derive percent complete and the ETA; it may be zero if unknown.
+func parseProgress(r io.Reader, duration float64, update func(jobProgress)) {
+	var p jobProgress
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "frame":
+			p.Frame, _ = strconv.ParseInt(value, 10, 64)
+		case "out_time_us", "out_time_ms":
+			// out_time_ms is also in microseconds, despite its name
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				p.OutTime = float64(us) / 1e6
+			}
+		case "speed":
+			p.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "bitrate":
+			p.BitrateKbps, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+		case "progress":
+			if duration > 0 {
+				p.Percent = min(p.OutTime/duration*100, 100)
+				if p.Speed > 0 {
+					p.ETA = max(duration-p.OutTime, 0) / p.Speed
+				}
+			}
+			if value == "end" {
+				p.Percent = 100
+				p.ETA = 0
+			}
+			p.UpdatedAt = time.Now()
+			update(p)
+		}
+	}
+	// Drain whatever is left so ffmpeg never blocks on a full pipe.
+	_, _ = io.Copy(io.Discard, r)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// pushMessage is a notification rendered as plain text, for the push
+// services that only take a title and a message: Telegram, Gotify and ntfy.
+type pushMessage struct {
+	Title string
+	Body  string
+	// Urgent is set for failures, which are sent with a higher priority.
+	Urgent bool
+}
+
+func pushSuccess(n notification) pushMessage {
+	job := n.Job
+	lines := []string{
+		n.FileName(),
+		fmt.Sprintf("%s → %s, saved %s (%.1f%%)", formatFileSize(job.InputSize), formatFileSize(job.OutputSize), formatFileSize(n.Saved()), 100-n.Ratio()),
+	}
+	if job.Quality != nil {
+		lines = append(lines, "Quality: "+job.Quality.String())
+	}
+	if job.Profile != "" && job.Profile != defaultProfileName {
+		lines = append(lines, "Profile: "+job.Profile)
+	}
+	if d := n.Duration(); d > 0 {
+		lines = append(lines, "Took "+d.Round(time.Second).String())
+	}
+	return pushMessage{Title: "✅ Compression Successful", Body: strings.Join(lines, "\n")}
+}
+
+func pushSkip(n notification) pushMessage {
+	job := n.Job
+	if job.State != jobNotWorthIt {
+		return pushMessage{
+			Title: "⏭️ Compression Skipped",
+			Body:  fmt.Sprintf("%s\n%s", n.FileName(), n.Message),
+		}
+	}
+	return pushMessage{
+		Title: "⚠️ Compression Not Worth It",
+		Body: fmt.Sprintf("%s\n%s → %s, original kept\n%s", n.FileName(),
+			formatFileSize(job.InputSize), formatFileSize(job.OutputSize), n.Message),
+	}
+}
+
+func pushFailure(n notification) pushMessage {
+	return pushMessage{
+		Title:  "❌ Compression Failed",
+		Body:   fmt.Sprintf("%s\n%s", n.FileName(), n.Message),
+		Urgent: true,
+	}
+}
+
+func pushDigest(d digest) pushMessage {
+	lines := []string{
+		fmt.Sprintf("%s – %s", d.From.Format("Jan 2 15:04"), d.To.Format("Jan 2 15:04")),
+		fmt.Sprintf("Compressed %d, saved %s", d.Succeeded, formatFileSize(d.Saved())),
+		fmt.Sprintf("Not worth it %d, skipped %d, failed %d", d.NotWorthIt, d.Skipped, len(d.Failures)),
+	}
+	if len(d.TopSavings) > 0 {
+		lines = append(lines, "", "Top savings:")
+		for _, job := range d.TopSavings {
+			lines = append(lines, fmt.Sprintf("• %s: %s", filepath.Base(job.Path), formatFileSize(job.InputSize-job.OutputSize)))
+		}
+	}
+	if len(d.Failures) > 0 {
+		lines = append(lines, "", "Failures:")
+		for _, job := range d.Failures {
+			lines = append(lines, fmt.Sprintf("• %s: %s", filepath.Base(job.Path), job.Error))
+		}
+	}
+	return pushMessage{Title: "📊 Compression Digest", Body: strings.Join(lines, "\n")}
+}
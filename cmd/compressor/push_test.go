@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushSuccess(t *testing.T) {
+	started := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+	finished := started.Add(90 * time.Second)
+	note := notification{Job: jobRecord{
+		Path:       "/in/holiday.mkv",
+		State:      jobSucceeded,
+		Profile:    "archive",
+		InputSize:  4 << 20,
+		OutputSize: 1 << 20,
+		StartedAt:  &started,
+		FinishedAt: &finished,
+	}}
+
+	msg := pushSuccess(note)
+	want := strings.Join([]string{
+		"holiday.mkv",
+		"4.0 MB → 1.0 MB, saved 3.0 MB (75.0%)",
+		"Profile: archive",
+		"Took 1m30s",
+	}, "\n")
+	if msg.Body != want {
+		t.Errorf("body = %q, want %q", msg.Body, want)
+	}
+	if msg.Urgent {
+		t.Error("success is urgent")
+	}
+}
+
+func TestPushFailureIsUrgent(t *testing.T) {
+	msg := pushFailure(notification{Job: jobRecord{Path: "/in/a.mkv", State: jobFailed}, Message: "exit status 1"})
+	if !msg.Urgent || msg.Body != "a.mkv\nexit status 1" {
+		t.Errorf("pushFailure = %+v", msg)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	metricSSIM = "ssim"
+	metricPSNR = "psnr"
+	metricVMAF = "vmaf"
+	metricAuto = "auto"
+)
+
+// defaultMinScores apply when VERIFY_MIN_SCORE is not set.
+var defaultMinScores = map[string]float64{
+	metricSSIM: 0.95,
+	metricPSNR: 35,
+	metricVMAF: 90,
+}
+
+var scorePatterns = map[string]*regexp.Regexp{
+	metricSSIM: regexp.MustCompile(`SSIM .*All:([0-9.]+)`),
+	metricPSNR: regexp.MustCompile(`PSNR .*average:([0-9.]+|inf)`),
+	metricVMAF: regexp.MustCompile(`VMAF score[:=]\s*([0-9.]+)`),
+}
+
+type qualityResult struct {
+	Metric string  `json:"metric"`
+	Score  float64 `json:"score"`
+}
+
+func (q qualityResult) String() string {
+	if q.Metric == metricSSIM {
+		return fmt.Sprintf("SSIM %.4f", q.Score)
+	}
+	return fmt.Sprintf("%s %.2f", strings.ToUpper(q.Metric), q.Score)
+}
+
+var (
+	libvmafOnce      sync.Once
+	libvmafAvailable bool
+)
+
+// hasLibvmaf reports whether the configured ffmpeg was built with libvmaf.
+func hasLibvmaf(cfg config) bool {
+	libvmafOnce.Do(func() {
+		out, err := exec.Command(cfg.ffmpegBinary, "-hide_banner", "-filters").Output()
+		libvmafAvailable = err == nil && bytes.Contains(out, []byte("libvmaf"))
+	})
+	return libvmafAvailable
+}
+
+// resolveMetric turns the configured metric into the one that will be run,
+// falling back to SSIM when libvmaf is requested but not available.
+func resolveMetric(cfg config) string {
+	switch cfg.verifyMetric {
+	case metricVMAF, metricAuto:
+		if hasLibvmaf(cfg) {
+			return metricVMAF
+		}
+		if cfg.verifyMetric == metricVMAF {
+			log.Printf("ffmpeg has no libvmaf, verifying with SSIM instead")
+		}
+		return metricSSIM
+	default:
+		return cfg.verifyMetric
+	}
+}
+
+// measureQuality compares the encoded output against the source with one of
+// ffmpeg's full reference metrics.
+func measureQuality(ctx context.Context, cfg config, sourcePath, outputPath string, source *probeResult) (qualityResult, error) {
+	metric := resolveMetric(cfg)
+	result := qualityResult{Metric: metric}
+
+	// The output is scaled back to the source resolution so profiles that
+	// downscale can still be compared.
+	scale := ""
+	if source != nil {
+		if v := source.video(); v != nil && v.Width > 0 && v.Height > 0 {
+			scale = fmt.Sprintf("scale=%d:%d:flags=bicubic,", v.Width, v.Height)
+		}
+	}
+	compare := metric
+	if metric == metricVMAF {
+		compare = "libvmaf"
+	}
+	filter := fmt.Sprintf("[0:v]%ssetpts=PTS-STARTPTS[dist];[1:v]setpts=PTS-STARTPTS[ref];[dist][ref]%s", scale, compare)
+
+	args := []string{
+		"-hide_banner",
+		"-nostats",
+		"-i", outputPath,
+		"-i", sourcePath,
+		"-lavfi", filter,
+		"-f", "null", "-",
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, cfg.ffmpegBinary, args...)
+	cmd.Stderr = &stderr
+
+	log.Printf("verifying quality (%s): %s", metric, outputPath)
+
+	if err := cmd.Run(); err != nil {
+		return result, fmt.Errorf("quality check failed: %w", err)
+	}
+
+	match := scorePatterns[metric].FindAllSubmatch(stderr.Bytes(), -1)
+	if len(match) == 0 {
+		return result, fmt.Errorf("quality check: no %s score in ffmpeg output", metric)
+	}
+	raw := string(match[len(match)-1][1])
+	if raw == "inf" {
+		// Identical frames have infinite PSNR.
+		result.Score = 100
+		return result, nil
+	}
+	score, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return result, fmt.Errorf("quality check: parse score %q: %w", raw, err)
+	}
+	result.Score = score
+	return result, nil
+}
+
+// minScore returns the configured threshold for metric.
+func minScore(cfg config, metric string) float64 {
+	if cfg.verifyMinScore > 0 {
+		return cfg.verifyMinScore
+	}
+	return defaultMinScores[metric]
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// errMaxAttempts marks a failure after which the job is not retried again.
+var errMaxAttempts = errors.New("max attempts reached")
+
+// errorReport is written next to a quarantined input to explain why it was
+// given up on.
+type errorReport struct {
+	JobID         string    `json:"job_id"`
+	Source        string    `json:"source"`
+	Attempts      int       `json:"attempts"`
+	Error         string    `json:"error"`
+	ExitCode      int       `json:"exit_code,omitempty"`
+	StderrTail    string    `json:"stderr_tail,omitempty"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// retryBackoff returns how long to wait before the next attempt after the
+// given number of failed attempts.
+func retryBackoff(cfg config, attempts int) time.Duration {
+	backoff := cfg.retryBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if cfg.retryBackoffMax > 0 && backoff >= cfg.retryBackoffMax {
+			return cfg.retryBackoffMax
+		}
+	}
+	return backoff
+}
+
+// quarantineFile moves a permanently failing input out of the input tree and
+// writes a sidecar error report next to it. It returns the new location.
+func quarantineFile(cfg config, srcPath, originalPath string, job jobRecord, cause error) (string, error) {
+	rel, err := filepath.Rel(cfg.inputDir, originalPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(originalPath)
+	}
+	dest := filepath.Join(cfg.quarantineDir, rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", fmt.Errorf("ensure quarantine dir: %w", err)
+	}
+	if _, err := os.Stat(dest); err == nil {
+		ext := filepath.Ext(dest)
+		dest = fmt.Sprintf("%s.%d%s", strings.TrimSuffix(dest, ext), time.Now().Unix(), ext)
+	}
+
+	if err := moveFile(srcPath, dest); err != nil {
+		return "", fmt.Errorf("move to quarantine: %w", err)
+	}
+
+	report := errorReport{
+		JobID:         job.ID,
+		Source:        originalPath,
+		Attempts:      job.Attempts,
+		Error:         cause.Error(),
+		QuarantinedAt: time.Now(),
+	}
+	var ffErr *ffmpegError
+	if errors.As(cause, &ffErr) {
+		report.ExitCode = ffErr.ExitCode
+		report.StderrTail = ffErr.StderrTail
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = os.WriteFile(dest+".error.json", data, 0o644)
+	}
+	if err != nil {
+		log.Printf("write error report for %s failed: %v", dest, err)
+	}
+
+	return dest, nil
+}
+
+// moveFile renames src to dst, falling back to copy and remove when the two
+// paths are on different filesystems.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
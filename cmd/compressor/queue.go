@@ -0,0 +1,173 @@
+package main
+
+import (
+	"container/heap"
+	"os"
+	"sort"
+	"time"
+)
+
+// Orders for QUEUE_ORDER.
+const (
+	queueOrderFIFO     = "fifo"
+	queueOrderOldest   = "oldest"
+	queueOrderSmallest = "smallest"
+	queueOrderSavings  = "savings"
+	queueOrderProfile  = "profile"
+)
+
+// defaultSavingsRatio is the share of a file expected to be saved while no
+// job with the same profile has finished yet.
+const defaultSavingsRatio = 0.5
+
+// queueItem is a path waiting in the queue together with the keys it is
+// ordered by. The keys are taken when the path is queued.
+type queueItem struct {
+	path     string
+	seq      uint64 // order of arrival
+	bumped   uint64 // order of the last bump, 0 if never bumped
+	modTime  time.Time
+	size     int64
+	savings  int64 // expected bytes saved
+	priority int   // priority of the expected profile
+	index    int   // position in the heap, maintained by jobQueue
+}
+
+// jobQueue is a priority queue of paths. Bumped paths come first, most
+// recently bumped ahead, then the rest in the configured order. Ties are
+// broken by arrival. It is not safe for concurrent use.
+type jobQueue struct {
+	order string
+	items []*queueItem
+	paths map[string]*queueItem
+	seq   uint64
+}
+
+func newJobQueue(order string) *jobQueue {
+	return &jobQueue{order: order, paths: make(map[string]*queueItem)}
+}
+
+// Len, Less, Swap, Push and Pop implement heap.Interface. Callers use the
+// lower case methods instead.
+func (q *jobQueue) Len() int { return len(q.items) }
+
+func (q *jobQueue) Less(i, j int) bool {
+	return q.before(q.items[i], q.items[j])
+}
+
+func (q *jobQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *jobQueue) Push(x any) {
+	item := x.(*queueItem)
+	item.index = len(q.items)
+	q.items = append(q.items, item)
+}
+
+func (q *jobQueue) Pop() any {
+	n := len(q.items) - 1
+	item := q.items[n]
+	q.items[n] = nil
+	q.items = q.items[:n]
+	item.index = -1
+	return item
+}
+
+func (q *jobQueue) before(a, b *queueItem) bool {
+	if a.bumped != b.bumped {
+		return a.bumped > b.bumped
+	}
+	switch q.order {
+	case queueOrderOldest:
+		if !a.modTime.Equal(b.modTime) {
+			return a.modTime.Before(b.modTime)
+		}
+	case queueOrderSmallest:
+		if a.size != b.size {
+			return a.size < b.size
+		}
+	case queueOrderSavings:
+		if a.savings != b.savings {
+			return a.savings > b.savings
+		}
+	case queueOrderProfile:
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+	}
+	return a.seq < b.seq
+}
+
+func (q *jobQueue) push(item *queueItem) {
+	q.seq++
+	item.seq = q.seq
+	q.paths[item.path] = item
+	heap.Push(q, item)
+}
+
+func (q *jobQueue) pop() string {
+	item := heap.Pop(q).(*queueItem)
+	delete(q.paths, item.path)
+	return item.path
+}
+
+// remove drops path from the queue and reports whether it was queued.
+func (q *jobQueue) remove(path string) bool {
+	item, ok := q.paths[path]
+	if !ok {
+		return false
+	}
+	heap.Remove(q, item.index)
+	delete(q.paths, path)
+	return true
+}
+
+// bump moves path to the front of the queue and reports whether it was
+// queued.
+func (q *jobQueue) bump(path string) bool {
+	item, ok := q.paths[path]
+	if !ok {
+		return false
+	}
+	q.seq++
+	item.bumped = q.seq
+	heap.Fix(q, item.index)
+	return true
+}
+
+// sorted returns the queued paths in the order they will be started.
+func (q *jobQueue) sorted() []string {
+	items := append([]*queueItem(nil), q.items...)
+	sort.Slice(items, func(i, j int) bool { return q.before(items[i], items[j]) })
+	paths := make([]string, len(items))
+	for i, item := range items {
+		paths[i] = item.path
+	}
+	return paths
+}
+
+// newQueueItem collects the ordering keys for path. The profile is guessed
+// from what is known before the file is probed, so rules on probed
+// properties only count for files probed on an earlier attempt.
+func newQueueItem(cfg config, path string, info os.FileInfo) *queueItem {
+	item := &queueItem{path: path, modTime: info.ModTime(), size: info.Size()}
+	if cfg.queueOrder != queueOrderSavings && cfg.queueOrder != queueOrderProfile {
+		return item
+	}
+
+	job, _ := jobs.get(path)
+	prof, ok := findProfile(cfg, job.PinnedProfile)
+	if job.PinnedProfile == "" || !ok {
+		prof = selectProfile(cfg, path, item.size, job.Probe)
+	}
+	item.priority = prof.Priority
+	ratio, ok := jobs.savingsRatio(prof.Name)
+	if !ok {
+		ratio = defaultSavingsRatio
+	}
+	item.savings = int64(float64(item.size) * ratio)
+	return item
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestJobQueueOrder(t *testing.T) {
+	base := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	// Queued in this order.
+	items := []queueItem{
+		{path: "a", modTime: base.Add(2 * time.Hour), size: 300, savings: 100, priority: 0},
+		{path: "b", modTime: base, size: 100, savings: 50, priority: 5},
+		{path: "c", modTime: base.Add(time.Hour), size: 200, savings: 150, priority: 5},
+		{path: "d", modTime: base, size: 100, savings: 150, priority: 10},
+	}
+
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{queueOrderFIFO, []string{"a", "b", "c", "d"}},
+		{queueOrderOldest, []string{"b", "d", "c", "a"}},
+		{queueOrderSmallest, []string{"b", "d", "c", "a"}},
+		{queueOrderSavings, []string{"c", "d", "a", "b"}},
+		{queueOrderProfile, []string{"d", "b", "c", "a"}},
+	}
+	for _, tt := range tests {
+		q := newJobQueue(tt.order)
+		for _, item := range items {
+			item := item
+			q.push(&item)
+		}
+		if got := q.sorted(); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: sorted() = %v, want %v", tt.order, got, tt.want)
+		}
+		var popped []string
+		for q.Len() > 0 {
+			popped = append(popped, q.pop())
+		}
+		if !slices.Equal(popped, tt.want) {
+			t.Errorf("%s: pop order = %v, want %v", tt.order, popped, tt.want)
+		}
+	}
+}
+
+func TestJobQueueBump(t *testing.T) {
+	tests := []struct {
+		name  string
+		order string
+		bumps []string
+		want  []string
+	}{
+		{"fifo", queueOrderFIFO, []string{"c"}, []string{"c", "a", "b", "d"}},
+		{"latest bump first", queueOrderFIFO, []string{"c", "d"}, []string{"d", "c", "a", "b"}},
+		{"bump again", queueOrderFIFO, []string{"c", "d", "c"}, []string{"c", "d", "a", "b"}},
+		{"bump beats order", queueOrderSmallest, []string{"d"}, []string{"d", "a", "b", "c"}},
+		{"unknown path", queueOrderFIFO, []string{"x"}, []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		q := newJobQueue(tt.order)
+		for i, path := range []string{"a", "b", "c", "d"} {
+			q.push(&queueItem{path: path, size: int64(100 * (i + 1))})
+		}
+		for _, path := range tt.bumps {
+			if got, want := q.bump(path), path != "x"; got != want {
+				t.Errorf("%s: bump(%q) = %t, want %t", tt.name, path, got, want)
+			}
+		}
+		if got := q.sorted(); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: sorted() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestJobQueueRemove(t *testing.T) {
+	q := newJobQueue(queueOrderFIFO)
+	for _, path := range []string{"a", "b", "c"} {
+		q.push(&queueItem{path: path})
+	}
+	if !q.remove("b") {
+		t.Fatal("remove(b) = false, want true")
+	}
+	if q.remove("b") {
+		t.Error("second remove(b) = true, want false")
+	}
+	if q.bump("b") {
+		t.Error("bump of removed path = true, want false")
+	}
+	if got, want := q.sorted(), []string{"a", "c"}; !slices.Equal(got, want) {
+		t.Errorf("sorted() = %v, want %v", got, want)
+	}
+	if got := q.pop(); got != "a" {
+		t.Errorf("pop() = %q, want a", got)
+	}
+}
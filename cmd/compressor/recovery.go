@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// instanceID identifies this process in processing markers. The random part
+// keeps it unique across container restarts where hostname and PID repeat.
+var instanceID = newInstanceID()
+
+// processingMarker is written next to every claimed input so other replicas,
+// and this process after a crash, can tell live work from orphaned work.
+type processingMarker struct {
+	Owner        string    `json:"owner"`
+	Host         string    `json:"host"`
+	PID          int       `json:"pid"`
+	OriginalPath string    `json:"original_path"`
+	OutputPath   string    `json:"output_path"`
+	StartedAt    time.Time `json:"started_at"`
+	Heartbeat    time.Time `json:"heartbeat"`
+}
+
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), newJobID())
+}
+
+func newProcessingMarker(originalPath, outputPath string) processingMarker {
+	host, _ := os.Hostname()
+	now := time.Now()
+	return processingMarker{
+		Owner:        instanceID,
+		Host:         host,
+		PID:          os.Getpid(),
+		OriginalPath: originalPath,
+		OutputPath:   outputPath,
+		StartedAt:    now,
+		Heartbeat:    now,
+	}
+}
+
+// markerPathFor returns the hidden marker file that belongs to processingPath.
+func markerPathFor(processingPath string) string {
+	return filepath.Join(filepath.Dir(processingPath), "."+filepath.Base(processingPath)+".owner")
+}
+
+func writeMarker(path string, m processingMarker) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+func readMarker(path string) (processingMarker, error) {
+	var m processingMarker
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("parse marker %s: %w", path, err)
+	}
+	return m, nil
+}
+
+func removeMarker(path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("remove processing marker %s failed: %v", path, err)
+	}
+}
+
+// startHeartbeat refreshes the marker until the returned stop func is called.
+// stop waits for the heartbeat goroutine so the marker can be removed safely.
+func startHeartbeat(path string, m processingMarker, every time.Duration) (stop func()) {
+	if every <= 0 {
+		every = time.Second
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				m.Heartbeat = time.Now()
+				if err := writeMarker(path, m); err != nil {
+					log.Printf("heartbeat %s failed: %v", path, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// recoverOrphans restores inputs left behind as processing files by a process
+// that died mid-encode, removes their partial outputs and queues them again.
+func recoverOrphans(cfg config, enqueue func(string)) error {
+	return scanDir(cfg, cfg.inputDir, func(path string) {
+		switch {
+		case strings.HasSuffix(path, cfg.processingSuffix):
+			recoverProcessingFile(cfg, path, enqueue)
+		case strings.HasSuffix(path, cfg.processingSuffix+".owner"):
+			cleanupStaleMarker(cfg, path)
+		}
+	})
+}
+
+func recoverProcessingFile(cfg config, processingPath string, enqueue func(string)) {
+	originalPath := strings.TrimSuffix(processingPath, cfg.processingSuffix)
+	if _, ok := cfg.extensions[strings.ToLower(filepath.Ext(originalPath))]; !ok {
+		return
+	}
+
+	markerPath := markerPathFor(processingPath)
+	marker, err := readMarker(markerPath)
+	hasMarker := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("recovery: %v", err)
+	}
+	if hasMarker && !markerIsStale(cfg, marker) {
+		return
+	}
+
+	if _, err := os.Stat(originalPath); err == nil {
+		log.Printf("recovery: cannot restore %s, %s already exists", processingPath, originalPath)
+		return
+	}
+
+	// The rename doubles as the claim: when several replicas notice the same
+	// orphan only one of them wins and cleans up after it.
+	if err := os.Rename(processingPath, originalPath); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("recovery: restore %s failed: %v", processingPath, err)
+		}
+		return
+	}
+
+	var outputPath string
+	if hasMarker {
+		log.Printf("recovery: restored %s (owner %s, last heartbeat %s)", originalPath, marker.Owner, marker.Heartbeat.Format(time.RFC3339))
+		outputPath = marker.OutputPath
+		removeMarker(markerPath)
+	} else {
+		log.Printf("recovery: restored %s (no owner marker)", originalPath)
+		outputPath = guessOutputPath(cfg, originalPath)
+	}
+	if outputPath != "" {
+		if err := os.Remove(outputPath); err == nil {
+			log.Printf("recovery: removed partial output %s", outputPath)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("recovery: remove partial output %s failed: %v", outputPath, err)
+		}
+	}
+
+	enqueue(originalPath)
+}
+
+// guessOutputPath returns where the interrupted encode of originalPath was
+// writing to when there is no marker to tell, from the ledger or else from
+// the profile it would be encoded with now.
+func guessOutputPath(cfg config, originalPath string) string {
+	job, _ := jobs.get(originalPath)
+	if job.OutputPath != "" {
+		return job.OutputPath
+	}
+	info, err := os.Stat(originalPath)
+	if err != nil {
+		return ""
+	}
+	prof, ok := findProfile(cfg, job.PinnedProfile)
+	if job.PinnedProfile == "" || !ok {
+		prof = selectProfile(cfg, originalPath, info.Size(), job.Probe)
+	}
+	path, err := outputPathFor(cfg, prof, originalPath)
+	if err != nil {
+		log.Printf("recovery: %v", err)
+		return ""
+	}
+	return path
+}
+
+// cleanupStaleMarker removes markers whose processing file is already gone,
+// e.g. when a crash happened between restoring the input and removing the marker.
+func cleanupStaleMarker(cfg config, markerPath string) {
+	marker, err := readMarker(markerPath)
+	if err != nil || !markerIsStale(cfg, marker) {
+		return
+	}
+	processingPath := filepath.Join(filepath.Dir(markerPath), strings.TrimSuffix(strings.TrimPrefix(filepath.Base(markerPath), "."), ".owner"))
+	if _, err := os.Stat(processingPath); err == nil {
+		return
+	}
+	removeMarker(markerPath)
+}
+
+func markerIsStale(cfg config, m processingMarker) bool {
+	if m.Owner == instanceID {
+		return false
+	}
+	return time.Since(m.Heartbeat) > cfg.staleAfter
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	notWorthItDiscard = "discard"
+	notWorthItCopy    = "copy"
+)
+
+// savingsShortfall returns why an encode did not save enough space to be
+// kept, or an empty string if it did.
+func savingsShortfall(cfg config, originalSize, compressedSize int64) string {
+	saved := originalSize - compressedSize
+	if saved <= 0 {
+		return fmt.Sprintf("output is %s larger than the original", formatFileSize(-saved))
+	}
+	if cfg.minSavingsBytes > 0 && saved < cfg.minSavingsBytes {
+		return fmt.Sprintf("saved %s, below the minimum of %s", formatFileSize(saved), formatFileSize(cfg.minSavingsBytes))
+	}
+	if originalSize > 0 && cfg.minSavingsPercent > 0 {
+		if percent := float64(saved) / float64(originalSize) * 100; percent < cfg.minSavingsPercent {
+			return fmt.Sprintf("saved %.1f%%, below the minimum of %.1f%%", percent, cfg.minSavingsPercent)
+		}
+	}
+	return ""
+}
+
+// keepOriginal drops an encode that was not worth keeping. With the copy
+// action the original is placed in the output dir instead, under its own
+// extension, and that path is returned.
+func keepOriginal(cfg config, sourcePath, originalPath, outputPath string) (string, error) {
+	if err := os.Remove(outputPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("remove output: %w", err)
+	}
+	if cfg.notWorthItAction != notWorthItCopy {
+		return "", nil
+	}
+
+	dest := strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + filepath.Ext(originalPath)
+	if _, err := os.Stat(dest); err == nil {
+		return "", fmt.Errorf("copy original: %s already exists", dest)
+	}
+	if err := copyFile(sourcePath, dest); err != nil {
+		return "", fmt.Errorf("copy original: %w", err)
+	}
+	return dest, nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // SCHEDULE_TZ must work in images without zoneinfo
+)
+
+const (
+	schedulePolicyFinish = "finish"
+	schedulePolicyPause  = "pause"
+)
+
+const minutesPerDay = 24 * 60
+
+// schedule is a set of weekly windows in which new jobs may be started.
+type schedule struct {
+	rules []scheduleRule
+	loc   *time.Location
+}
+
+// scheduleRule opens from start to end, in minutes after midnight, on the
+// selected days. A window with end <= start runs past midnight into the
+// next day.
+type scheduleRule struct {
+	days       [7]bool // indexed by time.Weekday
+	start, end int
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// parseSchedule parses rules such as "weekdays 22:00-07:00; weekends all day".
+// Rules are separated by ";" or ",". Each rule is a day spec (daily,
+// weekdays, weekends, a day name or a range like mon-thu) followed by a time
+// range or "all day". A rule without days applies every day.
+func parseSchedule(spec string, loc *time.Location) (*schedule, error) {
+	s := &schedule{loc: loc}
+	for _, raw := range strings.FieldsFunc(spec, func(r rune) bool { return r == ';' || r == ',' }) {
+		raw = strings.ToLower(strings.TrimSpace(raw))
+		if raw == "" {
+			continue
+		}
+		rule, err := parseScheduleRule(raw)
+		if err != nil {
+			return nil, fmt.Errorf("schedule rule %q: %w", raw, err)
+		}
+		s.rules = append(s.rules, rule)
+	}
+	if len(s.rules) == 0 {
+		return nil, fmt.Errorf("schedule %q has no rules", spec)
+	}
+	return s, nil
+}
+
+func parseScheduleRule(raw string) (scheduleRule, error) {
+	var rule scheduleRule
+	fields := strings.Fields(raw)
+
+	daySpec := "daily"
+	if len(fields) > 0 && !strings.ContainsRune(fields[0], ':') && !strings.HasPrefix(fields[0], "all") {
+		daySpec, fields = fields[0], fields[1:]
+	}
+	switch daySpec {
+	case "daily", "everyday", "always":
+		rule.days = [7]bool{true, true, true, true, true, true, true}
+	case "weekdays":
+		for d := time.Monday; d <= time.Friday; d++ {
+			rule.days[d] = true
+		}
+	case "weekends":
+		rule.days[time.Saturday] = true
+		rule.days[time.Sunday] = true
+	default:
+		from, to, isRange := strings.Cut(daySpec, "-")
+		first, ok := weekdayNames[from]
+		if !ok {
+			return rule, fmt.Errorf("unknown day %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdayNames[to]; !ok {
+				return rule, fmt.Errorf("unknown day %q", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			rule.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	switch timeSpec := strings.Join(fields, " "); timeSpec {
+	case "", "all day", "allday", "all-day":
+		rule.start, rule.end = 0, minutesPerDay
+	default:
+		from, to, ok := strings.Cut(timeSpec, "-")
+		if !ok {
+			return rule, fmt.Errorf("invalid time range %q, expected HH:MM-HH:MM", timeSpec)
+		}
+		var err error
+		if rule.start, err = parseClock(from); err != nil {
+			return rule, err
+		}
+		if rule.end, err = parseClock(to); err != nil {
+			return rule, err
+		}
+	}
+	return rule, nil
+}
+
+// parseClock parses HH:MM into minutes after midnight. 24:00 is accepted as
+// the end of the day.
+func parseClock(s string) (int, error) {
+	hh, mm, ok := strings.Cut(strings.TrimSpace(s), ":")
+	h, errH := strconv.Atoi(hh)
+	m, errM := strconv.Atoi(mm)
+	if !ok || errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h*60+m > minutesPerDay {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return h*60 + m, nil
+}
+
+// open reports whether t falls inside any window.
+func (s *schedule) open(t time.Time) bool {
+	t = t.In(s.loc)
+	day := t.Weekday()
+	minute := t.Hour()*60 + t.Minute()
+	for _, r := range s.rules {
+		if r.end > r.start {
+			if r.days[day] && minute >= r.start && minute < r.end {
+				return true
+			}
+			continue
+		}
+		// Past midnight: the late part belongs to today, the early part to
+		// a window that opened yesterday.
+		if (r.days[day] && minute >= r.start) || (r.days[(day+6)%7] && minute < r.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextChange returns when open(t) next differs from open(now), or the zero
+// time if it never does.
+func (s *schedule) nextChange(now time.Time) time.Time {
+	current := s.open(now)
+	t := now.In(s.loc).Truncate(time.Minute)
+	for i := 0; i < 8*minutesPerDay; i++ {
+		t = t.Add(time.Minute)
+		if s.open(t) != current {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// startSchedule applies the schedule to the dispatcher and keeps it inside
+// the configured windows until ctx is done. With the pause policy running
+// encodes are suspended while the window is closed.
+func startSchedule(ctx context.Context, cfg config, d *dispatcher) {
+	if cfg.schedule == nil {
+		return
+	}
+	update := func() {
+		open := cfg.schedule.open(time.Now())
+		if !d.setWindow(open, cfg.schedule.nextChange(time.Now())) {
+			return
+		}
+		if cfg.schedulePolicy == schedulePolicyPause {
+			if open {
+				encoders.resume()
+			} else {
+				encoders.suspend()
+			}
+		}
+	}
+	update()
+
+	go func() {
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				// Let suspended encodes use the shutdown grace period
+				encoders.resume()
+				return
+			case <-ticker.C:
+				update()
+			}
+		}
+	}()
+}
+
+// encoderSet tracks running ffmpeg encodes so they can be suspended outside
+// the schedule.
+type encoderSet struct {
+	mu        sync.Mutex
+	procs     map[*os.Process]struct{}
+	suspended bool
+}
+
+var encoders = &encoderSet{procs: make(map[*os.Process]struct{})}
+
+// add registers a started encode. It is suspended at once if encodes are
+// currently suspended.
+func (e *encoderSet) add(p *os.Process) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.procs[p] = struct{}{}
+	if e.suspended {
+		if err := suspendProcess(p); err != nil {
+			log.Printf("suspend ffmpeg %d: %v", p.Pid, err)
+		}
+	}
+}
+
+func (e *encoderSet) remove(p *os.Process) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.procs, p)
+}
+
+func (e *encoderSet) suspend() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.suspended = true
+	for p := range e.procs {
+		log.Printf("suspending ffmpeg %d until the schedule opens", p.Pid)
+		if err := suspendProcess(p); err != nil {
+			log.Printf("suspend ffmpeg %d: %v", p.Pid, err)
+		}
+	}
+}
+
+func (e *encoderSet) resume() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.suspended {
+		return
+	}
+	e.suspended = false
+	for p := range e.procs {
+		log.Printf("resuming ffmpeg %d", p.Pid)
+		if err := resumeProcess(p); err != nil {
+			log.Printf("resume ffmpeg %d: %v", p.Pid, err)
+		}
+	}
+}
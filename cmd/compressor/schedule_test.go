@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// jan2024 returns a time in January 2024, which starts on a Monday.
+func jan2024(day int, hh, mm int) time.Time {
+	return time.Date(2024, time.January, day, hh, mm, 0, 0, time.UTC)
+}
+
+func TestParseSchedule(t *testing.T) {
+	weekdays := [7]bool{false, true, true, true, true, true, false}
+	weekends := [7]bool{true, false, false, false, false, false, true}
+	daily := [7]bool{true, true, true, true, true, true, true}
+
+	tests := []struct {
+		spec    string
+		want    []scheduleRule
+		wantErr bool
+	}{
+		{spec: "22:00-07:00", want: []scheduleRule{{days: daily, start: 22 * 60, end: 7 * 60}}},
+		{spec: "weekdays 22:00-07:00; weekends all day", want: []scheduleRule{
+			{days: weekdays, start: 22 * 60, end: 7 * 60},
+			{days: weekends, start: 0, end: minutesPerDay},
+		}},
+		{spec: "Mon-Wed 01:30-05:00, sat 00:00-24:00", want: []scheduleRule{
+			{days: [7]bool{false, true, true, true, false, false, false}, start: 90, end: 300},
+			{days: [7]bool{6: true}, start: 0, end: minutesPerDay},
+		}},
+		{spec: "fri-mon allday", want: []scheduleRule{
+			{days: [7]bool{true, true, false, false, false, true, true}, start: 0, end: minutesPerDay},
+		}},
+		{spec: " ; daily ;", want: []scheduleRule{{days: daily, start: 0, end: minutesPerDay}}},
+		{spec: "", wantErr: true},
+		{spec: "funday 01:00-02:00", wantErr: true},
+		{spec: "mon-someday 01:00-02:00", wantErr: true},
+		{spec: "weekdays 22:00", wantErr: true},
+		{spec: "weekdays 25:00-07:00", wantErr: true},
+		{spec: "weekdays 22:60-07:00", wantErr: true},
+		{spec: "weekdays 24:01-07:00", wantErr: true},
+	}
+	for _, tt := range tests {
+		s, err := parseSchedule(tt.spec, time.UTC)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSchedule(%q) = %+v, want error", tt.spec, s.rules)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		if len(s.rules) != len(tt.want) {
+			t.Errorf("parseSchedule(%q) = %+v, want %+v", tt.spec, s.rules, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if s.rules[i] != tt.want[i] {
+				t.Errorf("parseSchedule(%q) rule %d = %+v, want %+v", tt.spec, i, s.rules[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestScheduleOpen(t *testing.T) {
+	tests := []struct {
+		spec string
+		at   time.Time
+		want bool
+	}{
+		{"weekdays 22:00-07:00", jan2024(1, 21, 59), false},
+		{"weekdays 22:00-07:00", jan2024(1, 22, 0), true},
+		{"weekdays 22:00-07:00", jan2024(1, 23, 59), true},
+		// Past midnight on Tuesday, the window opened on Monday.
+		{"weekdays 22:00-07:00", jan2024(2, 0, 0), true},
+		{"weekdays 22:00-07:00", jan2024(2, 6, 59), true},
+		{"weekdays 22:00-07:00", jan2024(2, 7, 0), false},
+		// Saturday morning still belongs to Friday night.
+		{"weekdays 22:00-07:00", jan2024(6, 3, 0), true},
+		{"weekdays 22:00-07:00", jan2024(6, 22, 0), false},
+		// Monday morning follows a Sunday without a window.
+		{"weekdays 22:00-07:00", jan2024(1, 3, 0), false},
+		{"weekdays 01:00-05:00", jan2024(1, 0, 59), false},
+		{"weekdays 01:00-05:00", jan2024(1, 1, 0), true},
+		{"weekdays 01:00-05:00", jan2024(1, 5, 0), false},
+		{"weekends all day", jan2024(7, 23, 59), true},
+		{"weekends all day", jan2024(8, 0, 0), false},
+		{"weekdays 22:00-07:00; weekends all day", jan2024(8, 3, 0), false},
+		{"weekdays 22:00-07:00; weekends all day", jan2024(7, 12, 0), true},
+	}
+	for _, tt := range tests {
+		s, err := parseSchedule(tt.spec, time.UTC)
+		if err != nil {
+			t.Fatalf("parseSchedule(%q): %v", tt.spec, err)
+		}
+		if got := s.open(tt.at); got != tt.want {
+			t.Errorf("%q open at %s = %t, want %t", tt.spec, tt.at.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestScheduleOpenInLocation(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := parseSchedule("daily 22:00-07:00", loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 21:30 UTC is 22:30 in Berlin in winter.
+	if !s.open(jan2024(1, 21, 30)) {
+		t.Error("window closed at 22:30 Berlin time, want open")
+	}
+	if s.open(jan2024(1, 6, 30)) {
+		t.Error("window open at 07:30 Berlin time, want closed")
+	}
+}
+
+func TestScheduleNextChange(t *testing.T) {
+	tests := []struct {
+		spec string
+		now  time.Time
+		want time.Time
+	}{
+		{"weekdays 22:00-07:00", jan2024(1, 12, 0), jan2024(1, 22, 0)},
+		{"weekdays 22:00-07:00", jan2024(1, 22, 30), jan2024(2, 7, 0)},
+		{"weekdays 22:00-07:00", jan2024(5, 23, 0), jan2024(6, 7, 0)},
+		// Closed from Saturday morning until Monday night.
+		{"weekdays 22:00-07:00", jan2024(6, 7, 0), jan2024(8, 22, 0)},
+		{"weekdays 22:00-07:00; weekends all day", jan2024(5, 23, 0), jan2024(8, 0, 0)},
+		{"weekdays 22:00-07:00", jan2024(1, 21, 59).Add(30 * time.Second), jan2024(1, 22, 0)},
+		{"daily all day", jan2024(1, 12, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := parseSchedule(tt.spec, time.UTC)
+		if err != nil {
+			t.Fatalf("parseSchedule(%q): %v", tt.spec, err)
+		}
+		if got := s.nextChange(tt.now); !got.Equal(tt.want) {
+			t.Errorf("%q next change after %s = %s, want %s", tt.spec, tt.now.Format("Mon 15:04:05"), got, tt.want)
+		}
+	}
+}
@@ -2,22 +2,45 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 )
 
-func runHTTPServer(ctx context.Context, port string) error {
+func runHTTPServer(ctx context.Context, port string, d *dispatcher) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
 	})
+	// /ready fails once a drain has finished or shutdown has begun so the
+	// instance is taken out of rotation; /status keeps reporting the process
+	// as alive.
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if mode := d.mode(); mode == "drained" || mode == "stopping" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(mode))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.Handle("/metrics", metricsHandler(d))
+	streams, stopStreams := context.WithCancel(context.Background())
+	api := http.NewServeMux()
+	api.Handle("/api/v1/events", eventsHandler(streams))
+	registerAPI(api, d)
+	mux.Handle("/api/v1/", requireAPIToken(d.cfg.apiToken, api))
+	mux.HandleFunc("/", serveDashboard)
 
 	server := &http.Server{
 		Addr:    ":" + port,
 		Handler: mux,
 	}
+	// Event streams never finish on their own, end them on shutdown
+	server.RegisterOnShutdown(stopStreams)
 
 	errCh := make(chan error, 1)
 	go func() {
@@ -39,3 +62,24 @@ func runHTTPServer(ctx context.Context, port string) error {
 		return err
 	}
 }
+
+// requireAPIToken rejects requests that do not carry token, either as a
+// bearer token or as ?token= for the browser's EventSource and image
+// requests, which cannot set headers. An empty token leaves h open.
+func requireAPIToken(token string, h http.Handler) http.Handler {
+	if token == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			got = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "missing or invalid API token")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAPIToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		token  string
+		target string
+		auth   string
+		want   int
+	}{
+		{"no token configured", "", "/api/v1/jobs", "", http.StatusNoContent},
+		{"missing", "s3cret", "/api/v1/jobs", "", http.StatusUnauthorized},
+		{"bearer", "s3cret", "/api/v1/jobs", "Bearer s3cret", http.StatusNoContent},
+		{"wrong bearer", "s3cret", "/api/v1/jobs", "Bearer nope", http.StatusUnauthorized},
+		{"other scheme", "s3cret", "/api/v1/jobs", "Basic s3cret", http.StatusUnauthorized},
+		{"query", "s3cret", "/api/v1/events?token=s3cret", "", http.StatusNoContent},
+		{"wrong query", "s3cret", "/api/v1/events?token=s3cre", "", http.StatusUnauthorized},
+		{"wrong bearer beats query", "s3cret", "/api/v1/jobs?token=s3cret", "Bearer nope", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		requireAPIToken(tt.token, ok).ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s: missing WWW-Authenticate", tt.name)
+		}
+	}
+}
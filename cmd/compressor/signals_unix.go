@@ -0,0 +1,32 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleControlSignals lets operators steer the dispatcher without the HTTP
+// API: SIGUSR1 toggles pause, SIGUSR2 starts draining.
+func handleControlSignals(ctx context.Context, d *dispatcher) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigs)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigs:
+			log.Printf("received %v", sig)
+			if sig == syscall.SIGUSR1 {
+				d.togglePause()
+			} else {
+				d.drain()
+			}
+		}
+	}
+}
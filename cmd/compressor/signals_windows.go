@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "context"
+
+// handleControlSignals is a no-op on Windows, which has no SIGUSR1/SIGUSR2.
+// Use the HTTP API to pause or drain instead.
+func handleControlSignals(ctx context.Context, d *dispatcher) {}
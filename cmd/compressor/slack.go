@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultSlackAPIURL = "https://slack.com/api"
+
+type SlackMessage struct {
+	Channel string       `json:"channel,omitempty"`
+	Text    string       `json:"text"`
+	Blocks  []SlackBlock `json:"blocks"`
+}
+
+type SlackBlock struct {
+	Type     string      `json:"type"`
+	Text     *SlackText  `json:"text,omitempty"`
+	Fields   []SlackText `json:"fields,omitempty"`
+	Elements []SlackText `json:"elements,omitempty"`
+}
+
+type SlackText struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Emoji bool   `json:"emoji,omitempty"`
+}
+
+// slackNotifier posts Block Kit messages to a Slack incoming webhook. With a
+// bot token and channel, successful encodes are posted through the Web API
+// instead so the thumbnail can be attached, and messages go through
+// chat.postMessage if there is no webhook.
+type slackNotifier struct {
+	webhookURL string
+	token      string
+	channel    string
+	apiURL     string
+}
+
+func (s *slackNotifier) Name() string { return "slack" }
+
+func (s *slackNotifier) Success(ctx context.Context, n notification) error {
+	job := n.Job
+	fields := []SlackText{
+		slackField("Original Size", formatFileSize(job.InputSize)),
+		slackField("Compressed Size", formatFileSize(job.OutputSize)),
+		slackField("Space Saved", formatFileSize(n.Saved())),
+		slackField("Compression Ratio", fmt.Sprintf("%.1f%%", n.Ratio())),
+	}
+	if job.Quality != nil {
+		fields = append(fields, slackField("Quality", job.Quality.String()))
+	}
+	if job.Profile != "" && job.Profile != defaultProfileName {
+		fields = append(fields, slackField("Profile", job.Profile))
+	}
+
+	msg := SlackMessage{
+		Text: fmt.Sprintf("Compression successful: %s", n.FileName()),
+		Blocks: []SlackBlock{
+			slackHeader("✅ Compression Successful"),
+			slackSection(fmt.Sprintf("compressed: *%s*", slackEscape(n.FileName()))),
+			{Type: "section", Fields: fields},
+			slackTimestamp(),
+		},
+	}
+
+	if n.Thumbnail != "" && s.token != "" && s.channel != "" {
+		err := s.sendWithFile(ctx, msg, n.Thumbnail, "thumbnail.jpg")
+		if err == nil {
+			return nil
+		}
+		log.Printf("slack: sending %s without thumbnail: %v", n.FileName(), err)
+	}
+	return s.send(ctx, msg)
+}
+
+func (s *slackNotifier) Skip(ctx context.Context, n notification) error {
+	job := n.Job
+	if job.State != jobNotWorthIt {
+		return s.send(ctx, SlackMessage{
+			Text: fmt.Sprintf("Compression skipped: %s", n.FileName()),
+			Blocks: []SlackBlock{
+				slackHeader("⏭️ Compression Skipped"),
+				slackSection(fmt.Sprintf("left as is: *%s*", slackEscape(n.FileName()))),
+				{Type: "section", Fields: []SlackText{slackField("Reason", n.Message)}},
+				slackTimestamp(),
+			},
+		})
+	}
+
+	outcome := "Compressed output discarded, original kept"
+	if job.OutputPath != "" {
+		outcome = "Compressed output discarded, original copied to output"
+	}
+	return s.send(ctx, SlackMessage{
+		Text: fmt.Sprintf("Compression not worth it: %s", n.FileName()),
+		Blocks: []SlackBlock{
+			slackHeader("⚠️ Compression Not Worth It"),
+			slackSection(fmt.Sprintf("kept original: *%s*", slackEscape(n.FileName()))),
+			{Type: "section", Fields: []SlackText{
+				slackField("Original Size", formatFileSize(job.InputSize)),
+				slackField("Compressed Size", formatFileSize(job.OutputSize)),
+				slackField("Reason", n.Message),
+				slackField("Outcome", outcome),
+			}},
+			slackTimestamp(),
+		},
+	})
+}
+
+func (s *slackNotifier) Failure(ctx context.Context, n notification) error {
+	return s.send(ctx, SlackMessage{
+		Text: fmt.Sprintf("Compression failed: %s", n.FileName()),
+		Blocks: []SlackBlock{
+			slackHeader("❌ Compression Failed"),
+			slackSection(fmt.Sprintf("Failed to compress *%s*", slackEscape(n.FileName()))),
+			slackSection("*Error*\n```" + slackTruncate(slackEscape(n.Message), 2900) + "```"),
+			slackTimestamp(),
+		},
+	})
+}
+
+func (s *slackNotifier) Digest(ctx context.Context, d digest) error {
+	blocks := []SlackBlock{
+		slackHeader("📊 Compression Digest"),
+		slackSection(fmt.Sprintf("%s – %s", d.From.Format("Jan 2 15:04"), d.To.Format("Jan 2 15:04"))),
+		{Type: "section", Fields: []SlackText{
+			slackField("Compressed", strconv.Itoa(d.Succeeded)),
+			slackField("Space Saved", formatFileSize(d.Saved())),
+			slackField("Not Worth It / Skipped", fmt.Sprintf("%d / %d", d.NotWorthIt, d.Skipped)),
+			slackField("Failures", strconv.Itoa(len(d.Failures))),
+		}},
+	}
+	if len(d.TopSavings) > 0 {
+		lines := []string{"*Top Savings*"}
+		for _, job := range d.TopSavings {
+			lines = append(lines, fmt.Sprintf("• %s: %s saved", slackEscape(filepath.Base(job.Path)), formatFileSize(job.InputSize-job.OutputSize)))
+		}
+		blocks = append(blocks, slackSection(slackTruncate(strings.Join(lines, "\n"), 3000)))
+	}
+	if len(d.Failures) > 0 {
+		lines := []string{"*Failures*"}
+		for _, job := range d.Failures {
+			lines = append(lines, fmt.Sprintf("• %s: %s", slackEscape(filepath.Base(job.Path)), slackEscape(job.Error)))
+		}
+		blocks = append(blocks, slackSection(slackTruncate(strings.Join(lines, "\n"), 3000)))
+	}
+	return s.send(ctx, SlackMessage{
+		Text:   fmt.Sprintf("Compression digest: %d compressed, %s saved", d.Succeeded, formatFileSize(d.Saved())),
+		Blocks: blocks,
+	})
+}
+
+func slackHeader(text string) SlackBlock {
+	return SlackBlock{Type: "header", Text: &SlackText{Type: "plain_text", Text: text, Emoji: true}}
+}
+
+func slackSection(markdown string) SlackBlock {
+	return SlackBlock{Type: "section", Text: &SlackText{Type: "mrkdwn", Text: markdown}}
+}
+
+func slackField(name, value string) SlackText {
+	return SlackText{Type: "mrkdwn", Text: fmt.Sprintf("*%s*\n%s", name, slackTruncate(slackEscape(value), 1900))}
+}
+
+func slackTimestamp() SlackBlock {
+	now := time.Now()
+	return SlackBlock{Type: "context", Elements: []SlackText{{
+		Type: "mrkdwn",
+		Text: fmt.Sprintf("<!date^%d^{date_short_pretty} {time}|%s>", now.Unix(), now.Format(time.RFC3339)),
+	}}}
+}
+
+// slackEscape escapes the characters Slack treats as markup in mrkdwn text.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// slackTruncate shortens escaped text to fit the length limits of Block Kit
+// text. An entity at the cut is dropped whole.
+func slackTruncate(s string, limit int) string {
+	cut := truncateRunes(s, limit)
+	if cut == s {
+		return s
+	}
+	cut = strings.TrimSuffix(cut, "…")
+	if i := strings.LastIndexByte(cut, '&'); i >= 0 && !strings.Contains(cut[i:], ";") {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
+
+// send posts msg to the webhook, or to the channel through chat.postMessage
+// if there is no webhook.
+func (s *slackNotifier) send(ctx context.Context, msg SlackMessage) error {
+	if s.webhookURL == "" {
+		msg.Channel = s.channel
+		return s.call(ctx, "chat.postMessage", msg, nil)
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal Slack message: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create Slack request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("send Slack webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Slack webhook returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// sendWithFile uploads the file at path and shares it in the channel
+// together with msg, using Slack's external upload flow.
+func (s *slackNotifier) sendWithFile(ctx context.Context, msg SlackMessage, path, name string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read attachment: %w", err)
+	}
+
+	var upload struct {
+		UploadURL string `json:"upload_url"`
+		FileID    string `json:"file_id"`
+	}
+	form := url.Values{"filename": {name}, "length": {strconv.Itoa(len(data))}}
+	if err := s.call(ctx, "files.getUploadURLExternal", form, &upload); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, upload.UploadURL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("create Slack upload request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("upload to Slack: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Slack upload returned status %d", resp.StatusCode)
+	}
+
+	files, _ := json.Marshal([]map[string]string{{"id": upload.FileID, "title": msg.Text}})
+	blocks, err := json.Marshal(msg.Blocks)
+	if err != nil {
+		return fmt.Errorf("marshal Slack blocks: %w", err)
+	}
+	form = url.Values{"files": {string(files)}, "channel_id": {s.channel}, "blocks": {string(blocks)}}
+	return s.call(ctx, "files.completeUploadExternal", form, nil)
+}
+
+// call invokes a Slack Web API method with a JSON body, or a form body for
+// url.Values, and decodes the response into out if it is set.
+func (s *slackNotifier) call(ctx context.Context, method string, params any, out any) error {
+	var (
+		body        io.Reader
+		contentType string
+	)
+	if form, ok := params.(url.Values); ok {
+		body, contentType = strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"
+	} else {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("marshal Slack %s: %w", method, err)
+		}
+		body, contentType = bytes.NewReader(data), "application/json; charset=utf-8"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.apiURL, "/")+"/"+method, body)
+	if err != nil {
+		return fmt.Errorf("create Slack %s request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+s.token)
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("call Slack %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("read Slack %s response: %w", method, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Slack %s returned status %d", method, resp.StatusCode)
+	}
+
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("decode Slack %s response: %w", method, err)
+	}
+	if !result.OK {
+		return fmt.Errorf("Slack %s: %s", method, result.Error)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("decode Slack %s response: %w", method, err)
+		}
+	}
+	return nil
+}
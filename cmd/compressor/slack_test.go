@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSlackTruncate(t *testing.T) {
+	tests := []struct {
+		in    string
+		limit int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"a-&gt;b", 7, "a-&gt;b"},
+		{"a-&gt;b", 6, "a-…"},
+		{"a-&gt;bc", 7, "a-&gt;…"},
+		{"&amp;&amp;", 7, "&amp;…"},
+		{"&lt;&lt;&lt;", 6, "&lt;…"},
+		{"ääääää", 4, "äää…"},
+	}
+	for _, tt := range tests {
+		got := slackTruncate(tt.in, tt.limit)
+		if got != tt.want {
+			t.Errorf("slackTruncate(%q, %d) = %q, want %q", tt.in, tt.limit, got, tt.want)
+		}
+		if n := utf8.RuneCountInString(got); n > tt.limit {
+			t.Errorf("slackTruncate(%q, %d) has %d runes", tt.in, tt.limit, n)
+		}
+	}
+}
+
+// slackRecorder collects the requests made to a stand-in Slack server.
+type slackRecorder struct {
+	mu       sync.Mutex
+	webhooks []SlackMessage
+	calls    []*http.Request
+	forms    map[string]url.Values
+	uploads  [][]byte
+}
+
+func (rec *slackRecorder) webhook(w http.ResponseWriter, r *http.Request) {
+	var msg SlackMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rec.mu.Lock()
+	rec.webhooks = append(rec.webhooks, msg)
+	rec.mu.Unlock()
+	io.WriteString(w, "ok")
+}
+
+func newSlackServer(t *testing.T, rec *slackRecorder, uploadOK bool) *httptest.Server {
+	t.Helper()
+	rec.forms = make(map[string]url.Values)
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook", rec.webhook)
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		method := strings.TrimPrefix(r.URL.Path, "/api/")
+		r.ParseForm()
+		rec.mu.Lock()
+		rec.calls = append(rec.calls, r)
+		rec.forms[method] = r.PostForm
+		rec.mu.Unlock()
+		switch {
+		case method == "files.getUploadURLExternal" && !uploadOK:
+			io.WriteString(w, `{"ok": false, "error": "missing_scope"}`)
+		case method == "files.getUploadURLExternal":
+			json.NewEncoder(w).Encode(map[string]any{"ok": true, "upload_url": srv.URL + "/upload", "file_id": "F1"})
+		default:
+			io.WriteString(w, `{"ok": true}`)
+		}
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.uploads = append(rec.uploads, data)
+		rec.mu.Unlock()
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func writeThumbnail(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "thumb.jpg")
+	if err := os.WriteFile(path, []byte("jpeg"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSlackFailurePayload(t *testing.T) {
+	var rec slackRecorder
+	srv := newSlackServer(t, &rec, true)
+	s := &slackNotifier{webhookURL: srv.URL + "/webhook"}
+
+	message := "exit 1: <stream> & input->output " + strings.Repeat("x", 4000)
+	note := notification{Job: jobRecord{Path: "/in/<a>.mkv", State: jobFailed}, Message: message}
+	if err := s.Failure(context.Background(), note); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.webhooks) != 1 {
+		t.Fatalf("got %d webhook messages, want 1", len(rec.webhooks))
+	}
+	msg := rec.webhooks[0]
+	if msg.Text != "Compression failed: <a>.mkv" {
+		t.Errorf("text = %q", msg.Text)
+	}
+	if got := msg.Blocks[1].Text.Text; got != "Failed to compress *&lt;a&gt;.mkv*" {
+		t.Errorf("file section = %q", got)
+	}
+	errText := msg.Blocks[2].Text.Text
+	if !strings.HasPrefix(errText, "*Error*\n```exit 1: &lt;stream&gt; &amp; input-&gt;output xxx") {
+		t.Errorf("error section not escaped: %.80q", errText)
+	}
+	if !strings.HasSuffix(errText, "…```") || utf8.RuneCountInString(errText) > 3000 {
+		t.Errorf("error section not truncated to 3000 runes: %d runes, ends %q", utf8.RuneCountInString(errText), errText[len(errText)-10:])
+	}
+}
+
+func TestSlackSuccessWithThumbnail(t *testing.T) {
+	var rec slackRecorder
+	srv := newSlackServer(t, &rec, true)
+	s := &slackNotifier{webhookURL: srv.URL + "/webhook", token: "xoxb-1", channel: "C1", apiURL: srv.URL + "/api"}
+
+	note := notification{
+		Job:       jobRecord{Path: "/in/a.mkv", State: jobSucceeded, InputSize: 4 << 20, OutputSize: 1 << 20},
+		Thumbnail: writeThumbnail(t),
+	}
+	if err := s.Success(context.Background(), note); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.webhooks) != 0 {
+		t.Errorf("got %d webhook messages, want the message with the upload only", len(rec.webhooks))
+	}
+	if len(rec.uploads) != 1 || string(rec.uploads[0]) != "jpeg" {
+		t.Errorf("uploads = %q, want the thumbnail", rec.uploads)
+	}
+	for _, r := range rec.calls {
+		if got := r.Header.Get("Authorization"); got != "Bearer xoxb-1" {
+			t.Errorf("%s Authorization = %q", r.URL.Path, got)
+		}
+	}
+	complete := rec.forms["files.completeUploadExternal"]
+	if complete == nil {
+		t.Fatal("files.completeUploadExternal was not called")
+	}
+	if got := complete["channel_id"]; len(got) != 1 || got[0] != "C1" {
+		t.Errorf("channel_id = %v, want C1", got)
+	}
+	var blocks []SlackBlock
+	if err := json.Unmarshal([]byte(complete["blocks"][0]), &blocks); err != nil {
+		t.Fatalf("blocks: %v", err)
+	}
+	if len(blocks) != 4 || blocks[0].Text.Text != "✅ Compression Successful" {
+		t.Errorf("blocks = %+v", blocks)
+	}
+}
+
+func TestSlackSuccessFallsBackWithoutThumbnail(t *testing.T) {
+	var rec slackRecorder
+	srv := newSlackServer(t, &rec, false)
+	s := &slackNotifier{webhookURL: srv.URL + "/webhook", token: "xoxb-1", channel: "C1", apiURL: srv.URL + "/api"}
+
+	note := notification{
+		Job:       jobRecord{Path: "/in/a.mkv", State: jobSucceeded, InputSize: 4 << 20, OutputSize: 1 << 20},
+		Thumbnail: writeThumbnail(t),
+	}
+	if err := s.Success(context.Background(), note); err != nil {
+		t.Fatalf("Success() = %v, want the message sent without thumbnail", err)
+	}
+	if len(rec.webhooks) != 1 || rec.webhooks[0].Text != "Compression successful: a.mkv" {
+		t.Errorf("webhook messages = %+v, want the success message", rec.webhooks)
+	}
+}
+
+func TestSlackPostMessageWithoutWebhook(t *testing.T) {
+	var rec slackRecorder
+	srv := newSlackServer(t, &rec, true)
+	s := &slackNotifier{token: "xoxb-1", channel: "C1", apiURL: srv.URL + "/api"}
+
+	note := notification{Job: jobRecord{Path: "/in/a.mkv", State: jobSkipped}, Message: "already HEVC"}
+	if err := s.Skip(context.Background(), note); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.calls) != 1 || rec.calls[0].URL.Path != "/api/chat.postMessage" {
+		t.Fatalf("calls = %d, want one chat.postMessage", len(rec.calls))
+	}
+	if got := rec.calls[0].Header.Get("Content-Type"); got != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Values for SMTP_TLS.
+const (
+	smtpTLSStartTLS = "starttls"
+	smtpTLSImplicit = "tls"
+	smtpTLSNone     = "none"
+)
+
+// smtpNotifier sends notifications as plain text email. Per-job mails can be
+// turned off to only send digests.
+type smtpNotifier struct {
+	host     string
+	port     string
+	tlsMode  string
+	username string
+	password string
+	from     *mail.Address
+	to       []*mail.Address
+	perJob   bool
+}
+
+func (s *smtpNotifier) Name() string { return "email" }
+
+func (s *smtpNotifier) Success(ctx context.Context, n notification) error {
+	if !s.perJob {
+		return nil
+	}
+	job := n.Job
+	var body strings.Builder
+	fmt.Fprintf(&body, "Compressed %s\n\n", n.FileName())
+	fmt.Fprintf(&body, "Source:            %s\n", job.Path)
+	fmt.Fprintf(&body, "Output:            %s\n", job.OutputPath)
+	fmt.Fprintf(&body, "Original size:     %s\n", formatFileSize(job.InputSize))
+	fmt.Fprintf(&body, "Compressed size:   %s\n", formatFileSize(job.OutputSize))
+	fmt.Fprintf(&body, "Space saved:       %s\n", formatFileSize(n.Saved()))
+	fmt.Fprintf(&body, "Compression ratio: %.1f%%\n", n.Ratio())
+	if job.Quality != nil {
+		fmt.Fprintf(&body, "Quality:           %s\n", job.Quality)
+	}
+	if job.Profile != "" && job.Profile != defaultProfileName {
+		fmt.Fprintf(&body, "Profile:           %s\n", job.Profile)
+	}
+	if d := n.Duration(); d > 0 {
+		fmt.Fprintf(&body, "Duration:          %s\n", d.Round(time.Second))
+	}
+	subject := fmt.Sprintf("✅ Compressed %s, saved %s", n.FileName(), formatFileSize(n.Saved()))
+	return s.send(ctx, subject, body.String(), n.Thumbnail)
+}
+
+func (s *smtpNotifier) Skip(ctx context.Context, n notification) error {
+	if !s.perJob {
+		return nil
+	}
+	job := n.Job
+	var body strings.Builder
+	if job.State == jobNotWorthIt {
+		fmt.Fprintf(&body, "Compressing %s was not worth it, the original was kept.\n\n", n.FileName())
+		fmt.Fprintf(&body, "Source:          %s\n", job.Path)
+		fmt.Fprintf(&body, "Original size:   %s\n", formatFileSize(job.InputSize))
+		fmt.Fprintf(&body, "Compressed size: %s\n", formatFileSize(job.OutputSize))
+		fmt.Fprintf(&body, "Reason:          %s\n", n.Message)
+		return s.send(ctx, fmt.Sprintf("⚠️ Not worth compressing %s", n.FileName()), body.String(), "")
+	}
+	fmt.Fprintf(&body, "%s was left as it is.\n\n", n.FileName())
+	fmt.Fprintf(&body, "Source: %s\n", job.Path)
+	fmt.Fprintf(&body, "Reason: %s\n", n.Message)
+	return s.send(ctx, fmt.Sprintf("⏭️ Skipped %s", n.FileName()), body.String(), "")
+}
+
+func (s *smtpNotifier) Failure(ctx context.Context, n notification) error {
+	if !s.perJob {
+		return nil
+	}
+	job := n.Job
+	var body strings.Builder
+	fmt.Fprintf(&body, "Failed to compress %s\n\n", n.FileName())
+	fmt.Fprintf(&body, "Source:   %s\n", job.Path)
+	fmt.Fprintf(&body, "Attempts: %d\n", job.Attempts)
+	if job.QuarantinePath != "" {
+		fmt.Fprintf(&body, "Moved to: %s\n", job.QuarantinePath)
+	}
+	fmt.Fprintf(&body, "\n%s\n", n.Message)
+	return s.send(ctx, fmt.Sprintf("❌ Failed to compress %s", n.FileName()), body.String(), "")
+}
+
+func (s *smtpNotifier) Digest(ctx context.Context, d digest) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Compression digest for %s to %s\n\n", d.From.Format("Mon Jan 2 15:04"), d.To.Format("Mon Jan 2 15:04 MST"))
+	fmt.Fprintf(&body, "Compressed:    %d\n", d.Succeeded)
+	fmt.Fprintf(&body, "Space saved:   %s (%s to %s)\n", formatFileSize(d.Saved()), formatFileSize(d.InputBytes), formatFileSize(d.OutputBytes))
+	fmt.Fprintf(&body, "Not worth it:  %d\n", d.NotWorthIt)
+	fmt.Fprintf(&body, "Skipped:       %d\n", d.Skipped)
+	fmt.Fprintf(&body, "Failed:        %d\n", len(d.Failures))
+	if len(d.TopSavings) > 0 {
+		body.WriteString("\nTop savings\n")
+		for i, job := range d.TopSavings {
+			fmt.Fprintf(&body, "%d. %s: %s saved (%s to %s)\n", i+1, filepath.Base(job.Path),
+				formatFileSize(job.InputSize-job.OutputSize), formatFileSize(job.InputSize), formatFileSize(job.OutputSize))
+		}
+	}
+	if len(d.Failures) > 0 {
+		body.WriteString("\nFailures\n")
+		for _, job := range d.Failures {
+			fmt.Fprintf(&body, "- %s (%s): %s\n", filepath.Base(job.Path), job.State, job.Error)
+		}
+	}
+	subject := fmt.Sprintf("📊 Compression digest: %d compressed, %s saved", d.Succeeded, formatFileSize(d.Saved()))
+	return s.send(ctx, subject, body.String(), "")
+}
+
+// send delivers one mail to every recipient, with the JPEG at attachment
+// attached if it is set.
+func (s *smtpNotifier) send(ctx context.Context, subject, text, attachment string) error {
+	msg, err := s.message(subject, text, attachment)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(s.host, s.port)
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	tlsConfig := &tls.Config{ServerName: s.host}
+	if s.tlsMode == smtpTLSImplicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return fmt.Errorf("smtp greeting: %w", err)
+	}
+	defer c.Close()
+	if s.tlsMode == smtpTLSStartTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("smtp STARTTLS: %w", err)
+		}
+	}
+	if s.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := c.Mail(s.from.Address); err != nil {
+		return fmt.Errorf("smtp MAIL FROM: %w", err)
+	}
+	for _, to := range s.to {
+		if err := c.Rcpt(to.Address); err != nil {
+			return fmt.Errorf("smtp RCPT TO %s: %w", to.Address, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	return c.Quit()
+}
+
+// message builds the MIME message: plain text, or multipart/mixed with the
+// attachment.
+func (s *smtpNotifier) message(subject, text, attachment string) ([]byte, error) {
+	var image []byte
+	if attachment != "" {
+		var err error
+		if image, err = os.ReadFile(attachment); err != nil {
+			return nil, fmt.Errorf("read attachment: %w", err)
+		}
+	}
+
+	var b bytes.Buffer
+	id := make([]byte, 12)
+	rand.Read(id)
+	domain := s.host
+	if _, addrDomain, ok := strings.Cut(s.from.Address, "@"); ok {
+		domain = addrDomain
+	}
+	to := make([]string, len(s.to))
+	for i, addr := range s.to {
+		to[i] = addr.String()
+	}
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if image == nil {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+		b.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+		return b.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"8bit"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	part.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n")))
+
+	part, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"image/jpeg"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {`attachment; filename="thumbnail.jpg"`},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(image)
+	for len(encoded) > 76 {
+		part.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	part.Write([]byte(encoded + "\r\n"))
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
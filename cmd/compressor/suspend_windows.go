@@ -0,0 +1,21 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// canSuspend reports whether running encodes can be paused in place.
+const canSuspend = false
+
+var errSuspendUnsupported = errors.New("suspending processes is not supported on windows")
+
+func suspendProcess(p *os.Process) error {
+	return errSuspendUnsupported
+}
+
+func resumeProcess(p *os.Process) error {
+	return errSuspendUnsupported
+}
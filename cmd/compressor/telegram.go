@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const defaultTelegramAPIURL = "https://api.telegram.org"
+
+// Telegram limits the length of a message and of a photo caption.
+const (
+	telegramMessageLimit = 4096
+	telegramCaptionLimit = 1024
+)
+
+// telegramNotifier sends messages through the Telegram Bot API. Successful
+// encodes are sent as a photo of the thumbnail with the message as caption.
+type telegramNotifier struct {
+	apiURL string
+	token  string
+	chatID string
+}
+
+func (t *telegramNotifier) Name() string { return "telegram" }
+
+func (t *telegramNotifier) Success(ctx context.Context, n notification) error {
+	msg := pushSuccess(n)
+	if n.Thumbnail != "" {
+		err := t.sendPhoto(ctx, msg, n.Thumbnail)
+		if err == nil {
+			return nil
+		}
+		log.Printf("telegram: sending %s without thumbnail: %v", n.FileName(), err)
+	}
+	return t.sendMessage(ctx, msg)
+}
+
+func (t *telegramNotifier) Skip(ctx context.Context, n notification) error {
+	return t.sendMessage(ctx, pushSkip(n))
+}
+
+func (t *telegramNotifier) Failure(ctx context.Context, n notification) error {
+	return t.sendMessage(ctx, pushFailure(n))
+}
+
+func (t *telegramNotifier) Digest(ctx context.Context, d digest) error {
+	return t.sendMessage(ctx, pushDigest(d))
+}
+
+// telegramText formats msg as HTML with a bold title, cut to limit
+// characters before it is escaped.
+func telegramText(msg pushMessage, limit int) string {
+	title := truncateRunes(msg.Title, limit)
+	body := truncateRunes(msg.Body, limit-len([]rune(title))-2)
+	return "<b>" + html.EscapeString(title) + "</b>\n\n" + html.EscapeString(body)
+}
+
+func (t *telegramNotifier) sendMessage(ctx context.Context, msg pushMessage) error {
+	body, err := json.Marshal(map[string]any{
+		"chat_id":    t.chatID,
+		"text":       telegramText(msg, telegramMessageLimit),
+		"parse_mode": "HTML",
+	})
+	if err != nil {
+		return fmt.Errorf("marshal Telegram message: %w", err)
+	}
+	return t.call(ctx, "sendMessage", "application/json", bytes.NewReader(body))
+}
+
+// sendPhoto uploads the JPEG at path with msg as its caption.
+func (t *telegramNotifier) sendPhoto(ctx context.Context, msg pushMessage, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open attachment: %w", err)
+	}
+	defer file.Close()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("chat_id", t.chatID)
+	writer.WriteField("caption", telegramText(msg, telegramCaptionLimit))
+	writer.WriteField("parse_mode", "HTML")
+	part, err := writer.CreateFormFile("photo", "thumbnail.jpg")
+	if err != nil {
+		return fmt.Errorf("create form file: %w", err)
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return fmt.Errorf("copy attachment: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("close multipart writer: %w", err)
+	}
+	return t.call(ctx, "sendPhoto", writer.FormDataContentType(), &body)
+}
+
+func (t *telegramNotifier) call(ctx context.Context, method, contentType string, body io.Reader) error {
+	endpoint := strings.TrimSuffix(t.apiURL, "/") + "/bot" + t.token + "/" + method
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		// The URL contains the token, keep it out of the logs.
+		return fmt.Errorf("create Telegram %s request", method)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("call Telegram %s: %w", method, redactURLError(err))
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("Telegram %s returned status %d", method, resp.StatusCode)
+	}
+	if !result.OK {
+		return fmt.Errorf("Telegram %s: %s", method, result.Description)
+	}
+	return nil
+}
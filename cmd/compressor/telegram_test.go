@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTelegramText(t *testing.T) {
+	msg := pushMessage{Title: "❌ Compression Failed", Body: "<a>.mkv\nboom & bust"}
+	want := "<b>❌ Compression Failed</b>\n\n&lt;a&gt;.mkv\nboom &amp; bust"
+	if got := telegramText(msg, telegramMessageLimit); got != want {
+		t.Errorf("telegramText = %q, want %q", got, want)
+	}
+
+	// Telegram counts the limit after entities are parsed.
+	long := pushMessage{Title: "Title", Body: strings.Repeat("<&>", 1000)}
+	got := telegramText(long, telegramCaptionLimit)
+	text := html.UnescapeString(strings.NewReplacer("<b>", "", "</b>", "").Replace(got))
+	if n := utf8.RuneCountInString(text); n > telegramCaptionLimit {
+		t.Errorf("caption has %d characters, limit is %d", n, telegramCaptionLimit)
+	}
+	if !strings.HasSuffix(text, "…") {
+		t.Errorf("caption is not marked as cut: %q", text[len(text)-10:])
+	}
+}
+
+// telegramCall is a request received by a stand-in Bot API.
+type telegramCall struct {
+	method string
+	fields map[string]string
+	photo  []byte
+}
+
+func newTelegramServer(t *testing.T, token string, photoOK bool) (*httptest.Server, *[]telegramCall) {
+	t.Helper()
+	var calls []telegramCall
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, ok := strings.CutPrefix(r.URL.Path, "/bot"+token+"/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		call := telegramCall{method: method, fields: make(map[string]string)}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Errorf("parse %s: %v", method, err)
+			}
+			for name, values := range r.MultipartForm.Value {
+				call.fields[name] = values[0]
+			}
+			if f, _, err := r.FormFile("photo"); err == nil {
+				call.photo, _ = io.ReadAll(f)
+				f.Close()
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&call.fields); err != nil {
+			t.Errorf("decode %s: %v", method, err)
+		}
+		calls = append(calls, call)
+
+		if method == "sendPhoto" && !photoOK {
+			io.WriteString(w, `{"ok": false, "description": "Bad Request: IMAGE_PROCESS_FAILED"}`)
+			return
+		}
+		io.WriteString(w, `{"ok": true}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestTelegramFailure(t *testing.T) {
+	srv, calls := newTelegramServer(t, "123:abc", true)
+	tg := &telegramNotifier{apiURL: srv.URL, token: "123:abc", chatID: "-42"}
+
+	note := notification{Job: jobRecord{Path: "/in/<a>.mkv", State: jobFailed}, Message: "exit 1 & more"}
+	if err := tg.Failure(context.Background(), note); err != nil {
+		t.Fatal(err)
+	}
+	if len(*calls) != 1 || (*calls)[0].method != "sendMessage" {
+		t.Fatalf("calls = %+v, want one sendMessage", *calls)
+	}
+	fields := (*calls)[0].fields
+	if fields["chat_id"] != "-42" || fields["parse_mode"] != "HTML" {
+		t.Errorf("fields = %v", fields)
+	}
+	if want := "<b>❌ Compression Failed</b>\n\n&lt;a&gt;.mkv\nexit 1 &amp; more"; fields["text"] != want {
+		t.Errorf("text = %q, want %q", fields["text"], want)
+	}
+}
+
+func TestTelegramSuccessWithThumbnail(t *testing.T) {
+	srv, calls := newTelegramServer(t, "123:abc", true)
+	tg := &telegramNotifier{apiURL: srv.URL, token: "123:abc", chatID: "-42"}
+
+	note := notification{
+		Job:       jobRecord{Path: "/in/a.mkv", State: jobSucceeded, InputSize: 4 << 20, OutputSize: 1 << 20},
+		Thumbnail: writeThumbnail(t),
+	}
+	if err := tg.Success(context.Background(), note); err != nil {
+		t.Fatal(err)
+	}
+	if len(*calls) != 1 || (*calls)[0].method != "sendPhoto" {
+		t.Fatalf("calls = %+v, want one sendPhoto", *calls)
+	}
+	call := (*calls)[0]
+	if string(call.photo) != "jpeg" {
+		t.Errorf("photo = %q, want the thumbnail", call.photo)
+	}
+	if !strings.HasPrefix(call.fields["caption"], "<b>✅ Compression Successful</b>\n\na.mkv\n") {
+		t.Errorf("caption = %q", call.fields["caption"])
+	}
+}
+
+func TestTelegramSuccessFallsBackToMessage(t *testing.T) {
+	srv, calls := newTelegramServer(t, "123:abc", false)
+	tg := &telegramNotifier{apiURL: srv.URL, token: "123:abc", chatID: "-42"}
+
+	note := notification{
+		Job:       jobRecord{Path: "/in/a.mkv", State: jobSucceeded, InputSize: 4 << 20, OutputSize: 1 << 20},
+		Thumbnail: writeThumbnail(t),
+	}
+	if err := tg.Success(context.Background(), note); err != nil {
+		t.Fatalf("Success() = %v, want the message sent without thumbnail", err)
+	}
+	if len(*calls) != 2 || (*calls)[0].method != "sendPhoto" || (*calls)[1].method != "sendMessage" {
+		t.Fatalf("calls = %+v, want sendPhoto then sendMessage", *calls)
+	}
+}
+
+func TestTelegramErrorHidesToken(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	tg := &telegramNotifier{apiURL: srv.URL, token: "123:secret", chatID: "-42"}
+
+	err := tg.Digest(context.Background(), digest{})
+	if err == nil {
+		t.Fatal("Digest() succeeded against a closed server")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error leaks the token: %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// thumbnailPathFor returns where the thumbnail of a job's output is kept.
+func thumbnailPathFor(cfg config, id string) string {
+	return filepath.Join(cfg.stateDir, "thumbnails", id+".jpg")
+}
+
+// pruneThumbnails removes thumbnails whose job has left the ledger.
+func pruneThumbnails(cfg config) {
+	dir := filepath.Dir(thumbnailPathFor(cfg, ""))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("prune thumbnails: %v", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".jpg")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if _, known := jobs.getByID(id); known {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			log.Printf("prune thumbnails: %v", err)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// uploadTempPrefix marks uploads still being received. The leading dot keeps
+// them hidden from the scanner until they are renamed into place.
+const uploadTempPrefix = ".upload-"
+
+var errUploadName = errors.New("invalid file name")
+
+// uploadHandler accepts a video as multipart/form-data (the first file part)
+// or as a raw body named by ?filename=, stores it in the input directory and
+// queues it. ?profile= pins the encoding profile for this file.
+func uploadHandler(d *dispatcher) http.HandlerFunc {
+	cfg := d.cfg
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !cfg.uploadsEnabled {
+			writeError(w, http.StatusForbidden, "uploads are disabled")
+			return
+		}
+
+		query := r.URL.Query()
+		pinned := query.Get("profile")
+		if pinned != "" {
+			if _, ok := findProfile(cfg, pinned); !ok {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown profile %q", pinned))
+				return
+			}
+		}
+		if cfg.uploadMaxBytes > 0 {
+			if r.ContentLength > cfg.uploadMaxBytes {
+				writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("upload exceeds %s", formatFileSize(cfg.uploadMaxBytes)))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, cfg.uploadMaxBytes)
+		}
+
+		var (
+			name string
+			body io.Reader = r.Body
+		)
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+			mr, err := r.MultipartReader()
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			for {
+				part, err := mr.NextPart()
+				if errors.Is(err, io.EOF) {
+					writeError(w, http.StatusBadRequest, "no file in upload")
+					return
+				}
+				if err != nil {
+					writeUploadError(w, err)
+					return
+				}
+				if part.FileName() != "" {
+					name, body = part.FileName(), part
+					break
+				}
+			}
+		}
+		if filename := query.Get("filename"); filename != "" {
+			name = filename
+		}
+
+		name, err := uploadName(cfg, name)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		path, size, err := receiveUpload(cfg, name, pinned, body)
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		log.Printf("upload: received %s (%s)", path, formatFileSize(size))
+
+		// A new file, even if an earlier one had the same name
+		jobs.replace(path, func(j *jobRecord) { j.PinnedProfile = pinned })
+		d.submit(path)
+
+		job, _ := jobs.get(path)
+		w.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+		writeJSON(w, http.StatusCreated, job)
+	}
+}
+
+func writeUploadError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("upload exceeds %s", formatFileSize(tooLarge.Limit)))
+		return
+	}
+	log.Printf("upload: %v", err)
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+// uploadName reduces a client supplied file name to a plain base name with a
+// video extension.
+func uploadName(cfg config, name string) (string, error) {
+	// Browsers on Windows may send the full client path
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%w: missing, set ?filename=", errUploadName)
+	}
+	if strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("%w: %q is hidden", errUploadName, name)
+	}
+	if _, ok := cfg.extensions[strings.ToLower(filepath.Ext(name))]; !ok {
+		return "", fmt.Errorf("%w: %q is not a video file", errUploadName, name)
+	}
+	return name, nil
+}
+
+// receiveUpload writes body to a hidden temporary file in the input
+// directory and links it to name once complete. If a file of the same name
+// exists or was already encoded to the output, the upload gets a numbered
+// name instead.
+func receiveUpload(cfg config, name, pinned string, body io.Reader) (path string, size int64, err error) {
+	tmp, err := os.CreateTemp(cfg.inputDir, uploadTempPrefix+"*")
+	if err != nil {
+		return "", 0, fmt.Errorf("create upload file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if size, err = io.Copy(tmp, body); err != nil {
+		return "", 0, fmt.Errorf("receive upload: %w", err)
+	}
+	if err = tmp.Chmod(0o644); err != nil {
+		return "", 0, fmt.Errorf("receive upload: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return "", 0, fmt.Errorf("receive upload: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return "", 0, fmt.Errorf("receive upload: %w", err)
+	}
+
+	if path, err = linkUpload(cfg, tmp.Name(), name, pinned, size); err != nil {
+		return "", 0, err
+	}
+	if err := os.Remove(tmp.Name()); err != nil {
+		log.Printf("upload: remove %s: %v", tmp.Name(), err)
+	}
+	return path, size, nil
+}
+
+// linkUpload hard links tmp to the first free name derived from name.
+// Unlike a rename, a link fails if the name is taken, so two uploads of
+// the same name can never replace one another.
+func linkUpload(cfg config, tmp, name, pinned string, size int64) (string, error) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 0; i < 1000; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", stem, i, ext)
+		}
+		path := filepath.Join(cfg.inputDir, candidate)
+		if exists(path+cfg.processingSuffix) || outputTaken(cfg, path, pinned, size) {
+			continue
+		}
+		err := os.Link(tmp, path)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("move upload into place: %w", err)
+		}
+		return path, nil
+	}
+	return "", fmt.Errorf("no free name for upload %s", name)
+}
+
+// outputTaken reports whether the output an upload at path would be encoded
+// to already exists, in which case it would be skipped. The profile is
+// chosen without a probe, so rules on probed properties are not considered.
+func outputTaken(cfg config, path, pinned string, size int64) bool {
+	prof, ok := findProfile(cfg, pinned)
+	if pinned == "" || !ok {
+		prof = selectProfile(cfg, path, size, nil)
+	}
+	output, err := outputPathFor(cfg, prof, path)
+	return err == nil && exists(output)
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// cleanupUploads removes uploads that stopped receiving data, left behind
+// by a crash or a dropped connection. Another replica may be receiving into
+// the same directory, so only files untouched for staleAfter are removed.
+func cleanupUploads(cfg config) {
+	matches, err := filepath.Glob(filepath.Join(cfg.inputDir, uploadTempPrefix+"*"))
+	if err != nil {
+		return
+	}
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || time.Since(info.ModTime()) < cfg.staleAfter {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("remove incomplete upload %s: %v", path, err)
+			continue
+		}
+		log.Printf("removed incomplete upload %s", path)
+	}
+}
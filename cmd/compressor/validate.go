@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// validateOutput checks that an encode produced a complete file before the
+// source is allowed to be deleted: the duration must match the source, the
+// expected streams must be present and, optionally, the whole file must decode.
+func validateOutput(ctx context.Context, cfg config, outputPath string, source *probeResult) error {
+	out, err := probeVideo(ctx, cfg, outputPath)
+	if err != nil {
+		return fmt.Errorf("validate output: %w", err)
+	}
+
+	if source != nil && source.Duration > 0 {
+		diff := math.Abs(out.Duration - source.Duration)
+		if time.Duration(diff*float64(time.Second)) > cfg.durationTolerance {
+			return fmt.Errorf("validate output: duration %.1fs differs from source %.1fs", out.Duration, source.Duration)
+		}
+	}
+
+	wantVideo, wantAudio := cfg.minVideoStreams, cfg.minAudioStreams
+	if source != nil {
+		wantVideo = min(wantVideo, source.streamCount("video"))
+		wantAudio = min(wantAudio, source.streamCount("audio"))
+	} else {
+		// Without a source probe there is no telling whether audio is expected.
+		wantAudio = 0
+	}
+	if got := out.streamCount("video"); got < wantVideo {
+		return fmt.Errorf("validate output: %d video streams, expected %d", got, wantVideo)
+	}
+	if got := out.streamCount("audio"); got < wantAudio {
+		return fmt.Errorf("validate output: %d audio streams, expected %d", got, wantAudio)
+	}
+
+	if cfg.validateDecode {
+		if err := decodeCheck(ctx, cfg, outputPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeCheck decodes the whole file and fails on the first decoding error.
+func decodeCheck(ctx context.Context, cfg config, path string) error {
+	args := []string{
+		"-hide_banner",
+		"-nostats",
+		"-v", "error",
+		"-xerror",
+		"-i", path,
+		"-f", "null", "-",
+	}
+
+	stderrTail := &tailBuffer{max: 4096}
+	cmd := exec.CommandContext(ctx, cfg.ffmpegBinary, args...)
+	cmd.Stderr = stderrTail
+
+	log.Printf("decode check: %s", path)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("validate output: decode failed: %w: %s", err, strings.TrimSpace(stderrTail.String()))
+	}
+	return nil
+}
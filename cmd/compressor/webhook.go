@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultWebhookTemplate sends the whole payload as JSON.
+const defaultWebhookTemplate = `{{json .}}`
+
+// webhookPayload is the data WEBHOOK_TEMPLATE is rendered from.
+type webhookPayload struct {
+	// Event is the job state for job notifications, or "digest".
+	Event    string     `json:"event"`
+	Time     time.Time  `json:"time"`
+	Job      *jobRecord `json:"job,omitempty"`
+	Message  string     `json:"message,omitempty"`
+	FileName string     `json:"file_name,omitempty"`
+	Saved    int64      `json:"saved_bytes,omitempty"`
+	Ratio    float64    `json:"ratio_percent,omitempty"`
+	Duration float64    `json:"duration_seconds,omitempty"`
+	Digest   *digest    `json:"digest,omitempty"`
+}
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"size": formatFileSize,
+}
+
+// parseWebhookTemplate parses a body template. Besides the text/template
+// builtins it offers json, which encodes any value as JSON, and size, which
+// formats a byte count.
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(webhookFuncs).Parse(text)
+}
+
+// parseWebhookHeaders parses "Name: value" pairs, one per line. Header values
+// may contain ";", so lines are the only separator.
+func parseWebhookHeaders(s string) (http.Header, error) {
+	header := make(http.Header)
+	for _, raw := range strings.Split(s, "\n") {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(raw, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("header %q is not in the form Name: value", strings.TrimSpace(raw))
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return header, nil
+}
+
+// redactURL reduces a URL to its scheme and host for logging, as paths and
+// queries of webhook URLs often carry tokens.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "(invalid URL)"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// webhookNotifier renders a template for every notification and sends it to
+// an arbitrary HTTP endpoint, optionally signed with HMAC-SHA256.
+type webhookNotifier struct {
+	url      string
+	method   string
+	header   http.Header
+	template *template.Template
+	secret   string
+}
+
+func (w *webhookNotifier) Name() string { return "webhook" }
+
+func (w *webhookNotifier) Success(ctx context.Context, n notification) error {
+	return w.sendJob(ctx, n)
+}
+
+func (w *webhookNotifier) Failure(ctx context.Context, n notification) error {
+	return w.sendJob(ctx, n)
+}
+
+func (w *webhookNotifier) Skip(ctx context.Context, n notification) error {
+	return w.sendJob(ctx, n)
+}
+
+func (w *webhookNotifier) Digest(ctx context.Context, d digest) error {
+	return w.send(ctx, webhookPayload{Event: "digest", Time: time.Now(), Digest: &d})
+}
+
+func (w *webhookNotifier) sendJob(ctx context.Context, n notification) error {
+	job := n.Job
+	return w.send(ctx, webhookPayload{
+		Event:    string(job.State),
+		Time:     time.Now(),
+		Job:      &job,
+		Message:  n.Message,
+		FileName: n.FileName(),
+		Saved:    n.Saved(),
+		Ratio:    n.Ratio(),
+		Duration: n.Duration().Seconds(),
+	})
+}
+
+func (w *webhookNotifier) send(ctx context.Context, payload webhookPayload) error {
+	var body bytes.Buffer
+	if err := w.template.Execute(&body, payload); err != nil {
+		return fmt.Errorf("render webhook template: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, w.method, w.url, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return fmt.Errorf("create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range w.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("X-Compressor-Event", payload.Event)
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(body.Bytes())
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}